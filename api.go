@@ -4,6 +4,11 @@
 
 package modbus
 
+import (
+	"context"
+	"io"
+)
+
 type Client interface {
 	// Bit access
 
@@ -19,6 +24,17 @@ type Client interface {
 	// WriteMultipleCoils forces each coil in a sequence of coils to either
 	// ON or OFF in a remote device and returns quantity of outputs.
 	WriteMultipleCoils(address, quantity uint16, value []byte) (results []byte, err error)
+	// ReadCoilsBool is like ReadCoils but returns the status of each coil.
+	ReadCoilsBool(address, quantity uint16) (values []bool, err error)
+	// ReadDiscreteInputsBool is like ReadDiscreteInputs but returns the
+	// status of each discrete input.
+	ReadDiscreteInputsBool(address, quantity uint16) (values []bool, err error)
+	// WriteSingleCoilBool is like WriteSingleCoil but takes the status
+	// of the coil, true for ON.
+	WriteSingleCoilBool(address uint16, value bool) (err error)
+	// WriteMultipleCoilsBool is like WriteMultipleCoils but takes the
+	// status of each coil, whose number is the quantity.
+	WriteMultipleCoilsBool(address uint16, values []bool) (err error)
 
 	// 16-bit access
 
@@ -46,4 +62,145 @@ type Client interface {
 	//ReadFIFOQueue reads the contents of a First-In-First-Out (FIFO) queue
 	// of register in a remote device and returns FIFO value register.
 	ReadFIFOQueue(address uint16) (results []byte, err error)
+
+	// File record access
+
+	// ReadFileRecords reads the file records and returns them with their
+	// data. Records are split into as many requests as needed.
+	ReadFileRecords(records []FileRecord) (results []FileRecord, err error)
+	// WriteFileRecords writes data of the file records. Records are split
+	// into as many requests as needed.
+	WriteFileRecords(records []FileRecord) (err error)
+	// ReadFile reads all records of the file into w and returns the number
+	// of bytes written. ModbusError is returned if the file does not exist.
+	ReadFile(fileNumber uint16, w io.Writer) (n int64, err error)
+	// WriteFile writes data from r to the file starting from record 0 and
+	// returns the number of bytes written.
+	WriteFile(fileNumber uint16, r io.Reader) (n int64, err error)
+
+	// Diagnostics (serial line only)
+
+	// ReadExceptionStatus reads the contents of eight Exception Status
+	// outputs in a remote device and returns output data.
+	ReadExceptionStatus() (results []byte, err error)
+	// Diagnostics sends a diagnostics request with the sub-function code
+	// and data, and returns data in the response.
+	Diagnostics(subFunction uint16, data []byte) (results []byte, err error)
+	// ReturnQueryData sends data to be returned in the response.
+	ReturnQueryData(data []byte) (results []byte, err error)
+	// RestartCommunications initializes and restarts the serial line port
+	// of a remote device, bringing it out of Listen Only Mode. The
+	// communications event log is also cleared if clearLog is true.
+	RestartCommunications(clearLog bool) (err error)
+	// ReturnDiagnosticRegister returns the diagnostic register of a remote
+	// device.
+	ReturnDiagnosticRegister() (value uint16, err error)
+	// ChangeASCIIInputDelimiter changes the character replacing LF as the
+	// end of ASCII messages.
+	ChangeASCIIInputDelimiter(delimiter byte) (err error)
+	// ForceListenOnlyMode forces a remote device into Listen Only Mode.
+	// The device does not respond so no response is waited for.
+	ForceListenOnlyMode() (err error)
+	// ClearCounters clears all counters and the diagnostic register.
+	ClearCounters() (err error)
+	// ReturnBusMessageCount returns the number of messages detected on
+	// the bus by a remote device.
+	ReturnBusMessageCount() (count uint16, err error)
+	// ReturnBusCommunicationErrorCount returns the number of CRC errors.
+	ReturnBusCommunicationErrorCount() (count uint16, err error)
+	// ReturnBusExceptionErrorCount returns the number of exception
+	// responses returned by a remote device.
+	ReturnBusExceptionErrorCount() (count uint16, err error)
+	// ReturnServerMessageCount returns the number of messages addressed
+	// to a remote device.
+	ReturnServerMessageCount() (count uint16, err error)
+	// ReturnServerNoResponseCount returns the number of messages addressed
+	// to a remote device for which it has returned no response.
+	ReturnServerNoResponseCount() (count uint16, err error)
+	// ReturnServerNAKCount returns the number of Negative Acknowledge
+	// exception responses.
+	ReturnServerNAKCount() (count uint16, err error)
+	// ReturnServerBusyCount returns the number of Server Device Busy
+	// exception responses.
+	ReturnServerBusyCount() (count uint16, err error)
+	// ReturnBusCharacterOverrunCount returns the number of messages which
+	// a remote device could not handle due to a character overrun.
+	ReturnBusCharacterOverrunCount() (count uint16, err error)
+	// ClearOverrunCounter clears the overrun error counter.
+	ClearOverrunCounter() (err error)
+	// GetCommEventCounter returns the status word and the number of
+	// requests successfully completed by a remote device.
+	GetCommEventCounter() (counter *CommEventCounter, err error)
+	// GetCommEventLog returns the status word, event count, message count
+	// and the communication events of a remote device.
+	GetCommEventLog() (log *CommEventLog, err error)
+	// ReportServerId returns the server id, run indicator status and
+	// additional data of a remote device.
+	ReportServerId() (report *ServerIdReport, err error)
+
+	// Encapsulated Interface Transport
+
+	// ReadDeviceIdentification reads identification objects of a remote
+	// device. For stream access (ReadDeviceIdBasic, ReadDeviceIdRegular
+	// and ReadDeviceIdExtended), objects starting from objectId are read
+	// with follow-up requests until no more objects follow. For
+	// ReadDeviceIdIndividual, only the object objectId is read.
+	ReadDeviceIdentification(readDeviceIdCode, objectId byte) (identification *DeviceIdentification, err error)
+
+	// Send sends the request of any function code, including user-defined
+	// ones, and returns the response. Exception responses are returned as
+	// *ModbusError. Requests broadcast to slave id 0 on serial lines are
+	// not responded, so the response is nil.
+	Send(request *ProtocolDataUnit) (response *ProtocolDataUnit, err error)
+
+	// Unit returns a client which sends requests to the slave (unit) id
+	// instead of the SlaveId of the handler. Clients of all units share
+	// the transporter, so they can be used by multiple goroutines to
+	// access devices on the same serial line or behind the same gateway.
+	Unit(slaveId byte) Client
+
+	// Context-aware variants of the functions above. The request is
+	// aborted when ctx is done and ctx.Err() is returned.
+
+	ReadCoilsContext(ctx context.Context, address, quantity uint16) (results []byte, err error)
+	ReadDiscreteInputsContext(ctx context.Context, address, quantity uint16) (results []byte, err error)
+	WriteSingleCoilContext(ctx context.Context, address, value uint16) (results []byte, err error)
+	WriteMultipleCoilsContext(ctx context.Context, address, quantity uint16, value []byte) (results []byte, err error)
+	ReadCoilsBoolContext(ctx context.Context, address, quantity uint16) (values []bool, err error)
+	ReadDiscreteInputsBoolContext(ctx context.Context, address, quantity uint16) (values []bool, err error)
+	WriteSingleCoilBoolContext(ctx context.Context, address uint16, value bool) (err error)
+	WriteMultipleCoilsBoolContext(ctx context.Context, address uint16, values []bool) (err error)
+	ReadInputRegistersContext(ctx context.Context, address, quantity uint16) (results []byte, err error)
+	ReadHoldingRegistersContext(ctx context.Context, address, quantity uint16) (results []byte, err error)
+	WriteSingleRegisterContext(ctx context.Context, address, value uint16) (results []byte, err error)
+	WriteMultipleRegistersContext(ctx context.Context, address, quantity uint16, value []byte) (results []byte, err error)
+	ReadWriteMultipleRegistersContext(ctx context.Context, readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) (results []byte, err error)
+	MaskWriteRegisterContext(ctx context.Context, address, andMask, orMask uint16) (results []byte, err error)
+	ReadFIFOQueueContext(ctx context.Context, address uint16) (results []byte, err error)
+	ReadFileRecordsContext(ctx context.Context, records []FileRecord) (results []FileRecord, err error)
+	WriteFileRecordsContext(ctx context.Context, records []FileRecord) (err error)
+	ReadFileContext(ctx context.Context, fileNumber uint16, w io.Writer) (n int64, err error)
+	WriteFileContext(ctx context.Context, fileNumber uint16, r io.Reader) (n int64, err error)
+	ReadExceptionStatusContext(ctx context.Context) (results []byte, err error)
+	DiagnosticsContext(ctx context.Context, subFunction uint16, data []byte) (results []byte, err error)
+	ReturnQueryDataContext(ctx context.Context, data []byte) (results []byte, err error)
+	RestartCommunicationsContext(ctx context.Context, clearLog bool) (err error)
+	ReturnDiagnosticRegisterContext(ctx context.Context) (value uint16, err error)
+	ChangeASCIIInputDelimiterContext(ctx context.Context, delimiter byte) (err error)
+	ForceListenOnlyModeContext(ctx context.Context) (err error)
+	ClearCountersContext(ctx context.Context) (err error)
+	ReturnBusMessageCountContext(ctx context.Context) (count uint16, err error)
+	ReturnBusCommunicationErrorCountContext(ctx context.Context) (count uint16, err error)
+	ReturnBusExceptionErrorCountContext(ctx context.Context) (count uint16, err error)
+	ReturnServerMessageCountContext(ctx context.Context) (count uint16, err error)
+	ReturnServerNoResponseCountContext(ctx context.Context) (count uint16, err error)
+	ReturnServerNAKCountContext(ctx context.Context) (count uint16, err error)
+	ReturnServerBusyCountContext(ctx context.Context) (count uint16, err error)
+	ReturnBusCharacterOverrunCountContext(ctx context.Context) (count uint16, err error)
+	ClearOverrunCounterContext(ctx context.Context) (err error)
+	GetCommEventCounterContext(ctx context.Context) (counter *CommEventCounter, err error)
+	GetCommEventLogContext(ctx context.Context) (log *CommEventLog, err error)
+	ReportServerIdContext(ctx context.Context) (report *ServerIdReport, err error)
+	ReadDeviceIdentificationContext(ctx context.Context, readDeviceIdCode, objectId byte) (identification *DeviceIdentification, err error)
+	SendContext(ctx context.Context, request *ProtocolDataUnit) (response *ProtocolDataUnit, err error)
 }
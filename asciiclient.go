@@ -6,8 +6,10 @@ package modbus
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"time"
 )
 
@@ -32,6 +34,7 @@ func NewASCIIClientHandler(address string) *ASCIIClientHandler {
 	handler.Address = address
 	handler.Timeout = serialTimeout
 	handler.IdleTimeout = serialIdleTimeout
+	handler.TurnaroundDelay = serialTurnaroundDelay
 	return handler
 }
 
@@ -54,12 +57,27 @@ type asciiPackager struct {
 //  LRC             : 2 chars
 //  End             : 2 chars
 func (mb *asciiPackager) Encode(pdu *ProtocolDataUnit) (adu []byte, err error) {
+	return mb.encodeSlave(mb.SlaveId, pdu)
+}
+
+// isBroadcast returns true if the request is sent to slave id 0.
+func (mb *asciiPackager) isBroadcast(aduRequest []byte) bool {
+	return asciiBroadcast(aduRequest)
+}
+
+// asciiBroadcast returns true if the ASCII frame is sent to slave id 0.
+func asciiBroadcast(adu []byte) bool {
+	return len(adu) > 3 && string(adu[1:3]) == "00"
+}
+
+// encodeSlave is like Encode but sends the request to slaveId.
+func (mb *asciiPackager) encodeSlave(slaveId byte, pdu *ProtocolDataUnit) (adu []byte, err error) {
 	var buf bytes.Buffer
 
 	if _, err = buf.WriteString(asciiStart); err != nil {
 		return
 	}
-	if err = writeHex(&buf, []byte{mb.SlaveId, pdu.FunctionCode}); err != nil {
+	if err = writeHex(&buf, []byte{slaveId, pdu.FunctionCode}); err != nil {
 		return
 	}
 	if err = writeHex(&buf, pdu.Data); err != nil {
@@ -68,7 +86,7 @@ func (mb *asciiPackager) Encode(pdu *ProtocolDataUnit) (adu []byte, err error) {
 	// Exclude the beginning colon and terminating CRLF pair characters
 	var lrc lrc
 	lrc.reset()
-	lrc.pushByte(mb.SlaveId).pushByte(pdu.FunctionCode).pushBytes(pdu.Data)
+	lrc.pushByte(slaveId).pushByte(pdu.FunctionCode).pushBytes(pdu.Data)
 	if err = writeHex(&buf, []byte{lrc.value()}); err != nil {
 		return
 	}
@@ -149,7 +167,7 @@ func (mb *asciiPackager) Decode(adu []byte) (pdu *ProtocolDataUnit, err error) {
 	lrc.reset()
 	lrc.pushByte(address).pushByte(pdu.FunctionCode).pushBytes(pdu.Data)
 	if lrcVal != lrc.value() {
-		err = fmt.Errorf("modbus: response lrc '%v' does not match expected '%v'", lrcVal, lrc.value())
+		err = &checksumError{name: "lrc", checksum: uint16(lrcVal), expected: uint16(lrc.value())}
 		return
 	}
 	return
@@ -158,12 +176,50 @@ func (mb *asciiPackager) Decode(adu []byte) (pdu *ProtocolDataUnit, err error) {
 // asciiSerialTransporter implements Transporter interface.
 type asciiSerialTransporter struct {
 	serialPort
+	// Delay after a broadcast request, which is not responded, before
+	// sending the next request
+	TurnaroundDelay time.Duration
 }
 
 func (mb *asciiSerialTransporter) Send(aduRequest []byte) (aduResponse []byte, err error) {
-	mb.serialPort.mu.Lock()
-	defer mb.serialPort.mu.Unlock()
+	return mb.SendContext(context.Background(), aduRequest)
+}
+
+// SendContext is like Send but returns when ctx is done.
+func (mb *asciiSerialTransporter) SendContext(ctx context.Context, aduRequest []byte) (aduResponse []byte, err error) {
+	return mb.serialPort.sendContext(ctx, aduRequest, mb.send)
+}
+
+// sendOneWay writes the request without reading the response.
+func (mb *asciiSerialTransporter) sendOneWay(ctx context.Context, aduRequest []byte) (err error) {
+	_, err = mb.serialPort.sendContext(ctx, aduRequest, func(aduRequest []byte) (aduResponse []byte, err error) {
+		if err = mb.write(aduRequest); err != nil {
+			return
+		}
+		if asciiBroadcast(aduRequest) {
+			time.Sleep(mb.TurnaroundDelay)
+		}
+		return
+	})
+	return
+}
+
+// send writes the request and reads the response. Caller must hold the mutex.
+func (mb *asciiSerialTransporter) send(aduRequest []byte) (aduResponse []byte, err error) {
+	if err = mb.write(aduRequest); err != nil {
+		return
+	}
+	// Get the response
+	var data [asciiMaxSize]byte
+	if aduResponse, err = readASCIIResponse(mb.port, data[:]); err != nil {
+		return
+	}
+	mb.serialPort.logf("modbus: received %q\n", aduResponse)
+	return
+}
 
+// write connects and writes the request. Caller must hold the mutex.
+func (mb *asciiSerialTransporter) write(aduRequest []byte) (err error) {
 	// Make sure port is connected
 	if err = mb.serialPort.connect(); err != nil {
 		return
@@ -174,15 +230,17 @@ func (mb *asciiSerialTransporter) Send(aduRequest []byte) (aduResponse []byte, e
 
 	// Send the request
 	mb.serialPort.logf("modbus: sending %q\n", aduRequest)
-	if _, err = mb.port.Write(aduRequest); err != nil {
-		return
-	}
-	// Get the response
+	_, err = mb.port.Write(aduRequest)
+	return
+}
+
+// readASCIIResponse reads into data, which must be at least asciiMaxSize
+// bytes, until the end of frame is received.
+func readASCIIResponse(r io.Reader, data []byte) (aduResponse []byte, err error) {
 	var n int
-	var data [asciiMaxSize]byte
 	length := 0
 	for {
-		if n, err = mb.port.Read(data[length:]); err != nil {
+		if n, err = r.Read(data[length:asciiMaxSize]); err != nil {
 			return
 		}
 		length += n
@@ -197,7 +255,6 @@ func (mb *asciiSerialTransporter) Send(aduRequest []byte) (aduResponse []byte, e
 		}
 	}
 	aduResponse = data[:length]
-	mb.serialPort.logf("modbus: received %q\n", aduResponse)
 	return
 }
 
@@ -0,0 +1,83 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"time"
+)
+
+// ASCIIOverTCPClientHandler implements Packager and Transporter interface.
+// It sends ASCII frames over a TCP connection, which is usually provided by
+// serial device servers.
+type ASCIIOverTCPClientHandler struct {
+	asciiPackager
+	asciiTCPTransporter
+}
+
+// NewASCIIOverTCPClientHandler allocates a new ASCIIOverTCPClientHandler.
+func NewASCIIOverTCPClientHandler(address string) *ASCIIOverTCPClientHandler {
+	h := &ASCIIOverTCPClientHandler{}
+	h.Address = address
+	h.Timeout = tcpTimeout
+	h.IdleTimeout = tcpIdleTimeout
+	h.TurnaroundDelay = serialTurnaroundDelay
+	return h
+}
+
+// ASCIIOverTCPClient creates ASCII over TCP client with default handler and given connect string.
+func ASCIIOverTCPClient(address string) Client {
+	handler := NewASCIIOverTCPClientHandler(address)
+	return NewClient(handler)
+}
+
+// asciiTCPTransporter implements Transporter interface.
+type asciiTCPTransporter struct {
+	tcpTransporter
+	// Delay after a broadcast request, which is not responded, before
+	// sending the next request
+	TurnaroundDelay time.Duration
+}
+
+// Send sends data to server and reads the response until the end of frame.
+func (mb *asciiTCPTransporter) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	return mb.SendContext(context.Background(), aduRequest)
+}
+
+// SendContext is like Send but aborts the I/O when ctx is done.
+func (mb *asciiTCPTransporter) SendContext(ctx context.Context, aduRequest []byte) (aduResponse []byte, err error) {
+	return mb.tcpTransporter.sendContext(ctx, aduRequest, mb.exchange)
+}
+
+// sendOneWay writes the request without reading the response.
+func (mb *asciiTCPTransporter) sendOneWay(ctx context.Context, aduRequest []byte) (err error) {
+	_, err = mb.tcpTransporter.sendContext(ctx, aduRequest, func(aduRequest []byte) (aduResponse []byte, err error) {
+		mb.logf("modbus: sending %q\n", aduRequest)
+		if _, err = mb.conn.Write(aduRequest); err != nil {
+			return
+		}
+		if asciiBroadcast(aduRequest) {
+			time.Sleep(mb.TurnaroundDelay)
+		}
+		return
+	})
+	return
+}
+
+// exchange writes the request and reads the response. Caller must hold the mutex.
+func (mb *asciiTCPTransporter) exchange(aduRequest []byte) (aduResponse []byte, err error) {
+	mb.logf("modbus: sending %q\n", aduRequest)
+	if _, err = mb.conn.Write(aduRequest); err != nil {
+		return
+	}
+	var data [asciiMaxSize]byte
+	if aduResponse, err = readASCIIResponse(mb.conn, data[:]); err != nil {
+		// Without transaction id, a late response would be taken as
+		// the response of the next request so the caller drops the connection
+		return
+	}
+	mb.logf("modbus: received %q\n", aduResponse)
+	return
+}
@@ -0,0 +1,96 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestASCIIOverTCPClientHandler(t *testing.T) {
+	m := NewDataModel(10, 10, 10, 10)
+	m.SetInputRegisters(3, []uint16{0xABCD})
+	s := NewASCIIServer("", 2, m)
+	defer s.Close()
+
+	h := NewASCIIOverTCPClientHandler(startConnServer(t, s.Serve))
+	h.SlaveId = 2
+	h.Timeout = time.Second
+	defer h.Close()
+	client := NewClient(h)
+
+	results, err := client.ReadInputRegisters(3, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0xAB, 0xCD}, results) {
+		t.Fatalf("unexpected results: %v", results)
+	}
+	_, err = client.ReadInputRegisters(10, 1)
+	if mbError, ok := err.(*ModbusError); !ok || mbError.ExceptionCode != ExceptionCodeIllegalDataAddress {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestASCIIOverTCPClientHandlerFragmented(t *testing.T) {
+	// Response is split into several segments
+	address := startConnServer(t, func(conn io.ReadWriteCloser) error {
+		defer conn.Close()
+		var b [asciiMaxSize]byte
+		if _, err := conn.Read(b[:]); err != nil {
+			return err
+		}
+		for _, s := range []string{":0803", "04000A", "000B", "DC\r", "\n"} {
+			if _, err := conn.Write([]byte(s)); err != nil {
+				return err
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		_, err := conn.Read(b[:])
+		return err
+	})
+	h := NewASCIIOverTCPClientHandler(address)
+	h.SlaveId = 8
+	defer h.Close()
+
+	results, err := NewClient(h).ReadHoldingRegisters(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0, 10, 0, 11}, results) {
+		t.Fatalf("unexpected results: %v", results)
+	}
+}
+
+func TestASCIIOverTCPClientHandlerBroadcast(t *testing.T) {
+	requests := make(chan byte, 10)
+	handler := ServerHandlerFunc(func(slaveId byte, request *ProtocolDataUnit) (*ProtocolDataUnit, error) {
+		requests <- slaveId
+		return request, nil
+	})
+	s := NewASCIIServer("", 2, handler)
+	defer s.Close()
+
+	h := NewASCIIOverTCPClientHandler(startConnServer(t, s.Serve))
+	h.Timeout = time.Second
+	h.TurnaroundDelay = 50 * time.Millisecond
+	defer h.Close()
+	start := time.Now()
+	results, err := NewClient(h).WriteSingleRegister(1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < h.TurnaroundDelay {
+		t.Fatalf("unexpected turnaround delay: %v", elapsed)
+	}
+	if !bytes.Equal([]byte{0, 3}, results) {
+		t.Fatalf("unexpected results: %v", results)
+	}
+	if slaveId := <-requests; slaveId != 0 {
+		t.Fatalf("unexpected slave id: %v", slaveId)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/goburrow/serial"
+)
+
+// ASCIIServer serves modbus requests on a serial line in ASCII mode.
+type ASCIIServer struct {
+	serialServer
+}
+
+// NewASCIIServer allocates and initializes a ASCIIServer.
+func NewASCIIServer(address string, slaveId byte, handler ServerHandler) *ASCIIServer {
+	s := &ASCIIServer{}
+	s.Address = address
+	s.Timeout = serialTimeout
+	s.SlaveId = slaveId
+	s.Handler = handler
+	return s
+}
+
+// ListenAndServe opens the serial port in Address and then calls Serve.
+func (s *ASCIIServer) ListenAndServe() error {
+	port, err := s.open()
+	if err != nil {
+		return err
+	}
+	return s.Serve(port)
+}
+
+// Serve reads requests from the port and writes back responses.
+// It always returns a non-nil error, which is ErrServerClosed after Close
+// is called.
+func (s *ASCIIServer) Serve(port io.ReadWriteCloser) error {
+	if !s.start(port) {
+		port.Close()
+		return ErrServerClosed
+	}
+	frames := asciiFrameReader{r: port}
+	for {
+		// Delimiter may be changed by Diagnostics requests
+		frames.delimiter = s.asciiDelimiter
+		aduRequest, err := frames.readFrame()
+		for ; frames.overruns > 0; frames.overruns-- {
+			s.discard(true)
+		}
+		if err != nil {
+			return s.serveError(err)
+		}
+		s.logf("modbus: received %q\n", aduRequest)
+		aduResponse := s.handle(aduRequest)
+		if aduResponse == nil {
+			continue
+		}
+		s.logf("modbus: sending %q\n", aduResponse)
+		if _, err = port.Write(aduResponse); err != nil {
+			return s.serveError(err)
+		}
+	}
+}
+
+// handle processes the request frame and returns the response frame or
+// nil if there is no response.
+func (s *ASCIIServer) handle(aduRequest []byte) (aduResponse []byte) {
+	length := len(aduRequest)
+	// Minimum size (including address, function and LRC)
+	if length < asciiMinSize+6 {
+		s.logf("modbus: request length '%v' does not meet minimum '%v'", length, asciiMinSize+6)
+		s.discard(false)
+		return
+	}
+	// Length excluding colon must be an even number
+	if length%2 != 1 {
+		s.logf("modbus: request length '%v' is not an even number", length-1)
+		s.discard(false)
+		return
+	}
+	slaveId, err := readHex(aduRequest[1:])
+	if err != nil {
+		s.logf("modbus: %v", err)
+		s.discard(false)
+		return
+	}
+	return s.serialServer.handle(&asciiPackager{SlaveId: s.SlaveId}, slaveId, aduRequest)
+}
+
+// asciiFrameReader reads frames started with a colon and ended with CRLF.
+type asciiFrameReader struct {
+	r io.Reader
+	// Character replacing LF in the end of frames, if not zero
+	delimiter byte
+	// Data received but not returned yet
+	buf []byte
+	// Number of frames discarded for being too long
+	overruns uint16
+}
+
+// readFrame returns the next frame, including start and end characters.
+func (fr *asciiFrameReader) readFrame() (frame []byte, err error) {
+	var data [asciiMaxSize]byte
+	end := []byte(asciiEnd)
+	if fr.delimiter != 0 {
+		end[len(end)-1] = fr.delimiter
+	}
+	for {
+		// Discard everything before start of frame
+		if start := bytes.Index(fr.buf, []byte(asciiStart)); start >= 0 {
+			fr.buf = fr.buf[start:]
+			if i := bytes.Index(fr.buf, end); i >= 0 {
+				// Another start means the previous frame is incomplete
+				if next := bytes.LastIndex(fr.buf[1:i], []byte(asciiStart)); next >= 0 {
+					fr.buf = fr.buf[next+1:]
+					continue
+				}
+				frame = fr.buf[:i+len(end)]
+				fr.buf = fr.buf[i+len(end):]
+				return
+			}
+			// Frame is too long
+			if len(fr.buf) >= asciiMaxSize {
+				fr.buf = nil
+				fr.overruns++
+			}
+		} else {
+			fr.buf = nil
+		}
+		var n int
+		n, err = fr.r.Read(data[:])
+		fr.buf = append(fr.buf, data[:n]...)
+		if err != nil {
+			// Timeout only means the line is silent
+			if err != serial.ErrTimeout {
+				return
+			}
+			err = nil
+		}
+	}
+}
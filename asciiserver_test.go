@@ -0,0 +1,147 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestASCIIServer(t *testing.T) {
+	requests := make(chan byte, 10)
+	handler := ServerHandlerFunc(func(slaveId byte, request *ProtocolDataUnit) (*ProtocolDataUnit, error) {
+		requests <- slaveId
+		return &ProtocolDataUnit{
+			FunctionCode: request.FunctionCode,
+			Data:         []byte{6, 0, 1, 0, 2, 0, 3},
+		}, nil
+	})
+	serverPort, clientPort := net.Pipe()
+	defer clientPort.Close()
+	s := NewASCIIServer("", 17, handler)
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(serverPort)
+	}()
+
+	h := NewASCIIClientHandler("")
+	h.SlaveId = 17
+	h.port = clientPort
+	client := NewClient(h)
+	results, err := client.ReadHoldingRegisters(0x6B, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0, 1, 0, 2, 0, 3}, results) {
+		t.Fatalf("unexpected results: %v", results)
+	}
+	if slaveId := <-requests; slaveId != 17 {
+		t.Fatalf("unexpected slave id: %v", slaveId)
+	}
+
+	frames := []string{
+		// Other slave
+		":1203006B00037D\r\n",
+		// Invalid LRC
+		":1103006B00037F\r\n",
+		// Broadcast with noise
+		"\x00:11:0006000100039\r\n:000600010003F6\r\n",
+	}
+	for _, frame := range frames {
+		if _, err = clientPort.Write([]byte(frame)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if slaveId := <-requests; slaveId != 0 {
+		t.Fatalf("unexpected slave id: %v", slaveId)
+	}
+	clientPort.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	var b [1]byte
+	if n, err := clientPort.Read(b[:]); n != 0 || err == nil {
+		t.Fatalf("unexpected response: %v, %v", b[:n], err)
+	}
+	if len(requests) != 0 {
+		t.Fatalf("unexpected requests: %v", len(requests))
+	}
+
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-served; err != ErrServerClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestASCIIServerMalformed(t *testing.T) {
+	s := NewASCIIServer("", 17, ServerHandlerFunc(func(slaveId byte, request *ProtocolDataUnit) (*ProtocolDataUnit, error) {
+		t.Errorf("unexpected request: %+v", request)
+		return request, nil
+	}))
+	frames := []string{
+		// Too short
+		":1103\r\n",
+		// Odd length
+		":1103006B0003810\r\n",
+		// Slave id is not hexadecimal
+		":XY03006B000381\r\n",
+	}
+	for i, frame := range frames {
+		if aduResponse := s.handle([]byte(frame)); aduResponse != nil {
+			t.Fatalf("%q: unexpected response: %q", frame, aduResponse)
+		}
+		if n := s.counters.busCommunicationError; n != uint16(i+1) {
+			t.Fatalf("%q: unexpected bus communication errors: %v", frame, n)
+		}
+	}
+}
+
+func TestASCIIFrameReaderDelimiter(t *testing.T) {
+	frames := asciiFrameReader{
+		r:         bytes.NewReader([]byte(":0103\r\n:0104\r!")),
+		delimiter: '!',
+	}
+	frame, err := frames.readFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Frame ended with CRLF is incomplete
+	if string(frame) != ":0104\r!" {
+		t.Fatalf("unexpected frame: %q", frame)
+	}
+}
+
+func TestASCIIClientBroadcast(t *testing.T) {
+	requests := make(chan byte, 10)
+	handler := ServerHandlerFunc(func(slaveId byte, request *ProtocolDataUnit) (*ProtocolDataUnit, error) {
+		requests <- slaveId
+		return request, nil
+	})
+	serverPort, clientPort := net.Pipe()
+	defer clientPort.Close()
+	s := NewASCIIServer("", 17, handler)
+	defer s.Close()
+	go s.Serve(serverPort)
+
+	h := NewASCIIClientHandler("")
+	h.Timeout = time.Second
+	h.TurnaroundDelay = 10 * time.Millisecond
+	h.port = clientPort
+	client := NewClient(h)
+	results, err := client.WriteSingleRegister(1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0, 3}, results) {
+		t.Fatalf("unexpected results: %v", results)
+	}
+	if slaveId := <-requests; slaveId != 0 {
+		t.Fatalf("unexpected slave id: %v", slaveId)
+	}
+	if _, err = client.ReadCoils(0, 1); err == nil {
+		t.Fatal("expected error")
+	}
+}
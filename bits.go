@@ -0,0 +1,31 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+// PackBits packs the status of coils or discrete inputs into bytes, the
+// first value in the least significant bit of the first byte, as in
+// WriteMultipleCoils requests.
+func PackBits(values []bool) []byte {
+	data := make([]byte, (len(values)+7)/8)
+	for i, v := range values {
+		if v {
+			data[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return data
+}
+
+// UnpackBits returns quantity status of coils or discrete inputs packed in
+// data, e.g. results of ReadCoils. Status beyond data are false.
+func UnpackBits(data []byte, quantity int) []bool {
+	values := make([]bool, quantity)
+	for i := range values {
+		if i/8 >= len(data) {
+			break
+		}
+		values[i] = data[i/8]&(1<<uint(i%8)) != 0
+	}
+	return values
+}
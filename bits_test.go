@@ -0,0 +1,62 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestPackBits(t *testing.T) {
+	values := []bool{true, false, true, true, false, false, true, true, true, false}
+	data := PackBits(values)
+	if !bytes.Equal([]byte{0xCD, 0x01}, data) {
+		t.Fatalf("unexpected data: %x", data)
+	}
+	if actual := UnpackBits(data, len(values)); !reflect.DeepEqual(values, actual) {
+		t.Fatalf("unexpected values: %v", actual)
+	}
+	if actual := UnpackBits(data[:1], 9); actual[8] {
+		t.Fatalf("unexpected values: %v", actual)
+	}
+}
+
+func TestClientBool(t *testing.T) {
+	m := NewDataModel(20, 20, 0, 0)
+	m.SetDiscreteInputs(3, []bool{true, false, true})
+	s := NewTCPServer("", m)
+	defer s.Close()
+	h := NewTCPClientHandler(startTCPServer(t, s))
+	defer h.Close()
+	client := NewClient(h)
+
+	values, err := client.ReadDiscreteInputsBool(2, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]bool{false, true, false, true, false}, values) {
+		t.Fatalf("unexpected values: %v", values)
+	}
+	if err = client.WriteMultipleCoilsBool(1, []bool{true, true, false, true}); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.WriteSingleCoilBool(3, true); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.WriteSingleCoilBool(1, false); err != nil {
+		t.Fatal(err)
+	}
+	if values, err = client.ReadCoilsBool(0, 11); err != nil {
+		t.Fatal(err)
+	}
+	expected := []bool{false, false, true, true, true, false, false, false, false, false, false}
+	if !reflect.DeepEqual(expected, values) {
+		t.Fatalf("unexpected values: %v", values)
+	}
+	if err = client.WriteMultipleCoilsBool(0, make([]bool, 65536+1)); err == nil {
+		t.Fatal("error expected")
+	}
+}
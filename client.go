@@ -5,8 +5,11 @@
 package modbus
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
+	"io"
 )
 
 // ClientHandler is the interface that groups the Packager and Transporter methods.
@@ -18,6 +21,8 @@ type ClientHandler interface {
 type client struct {
 	packager    Packager
 	transporter Transporter
+	// Retry policy, requests are not retried if it is nil
+	retry *RetryPolicy
 }
 
 // NewClient creates a new modbus client with given backend handler.
@@ -39,6 +44,10 @@ func NewClient2(packager Packager, transporter Transporter) Client {
 //  Byte count            : 1 byte
 //  Coil status           : N* bytes (=N or N+1)
 func (mb *client) ReadCoils(address, quantity uint16) (results []byte, err error) {
+	return mb.ReadCoilsContext(context.Background(), address, quantity)
+}
+
+func (mb *client) ReadCoilsContext(ctx context.Context, address, quantity uint16) (results []byte, err error) {
 	if quantity < 1 || quantity > 2000 {
 		err = fmt.Errorf("modbus: quantity '%v' must be between '%v' and '%v',", quantity, 1, 2000)
 		return
@@ -47,7 +56,7 @@ func (mb *client) ReadCoils(address, quantity uint16) (results []byte, err error
 		FunctionCode: FuncCodeReadCoils,
 		Data:         dataBlock(address, quantity),
 	}
-	response, err := mb.send(&request)
+	response, err := mb.send(ctx, &request)
 	if err != nil {
 		return
 	}
@@ -70,6 +79,10 @@ func (mb *client) ReadCoils(address, quantity uint16) (results []byte, err error
 //  Byte count            : 1 byte
 //  Input status          : N* bytes (=N or N+1)
 func (mb *client) ReadDiscreteInputs(address, quantity uint16) (results []byte, err error) {
+	return mb.ReadDiscreteInputsContext(context.Background(), address, quantity)
+}
+
+func (mb *client) ReadDiscreteInputsContext(ctx context.Context, address, quantity uint16) (results []byte, err error) {
 	if quantity < 1 || quantity > 2000 {
 		err = fmt.Errorf("modbus: quantity '%v' must be between '%v' and '%v',", quantity, 1, 2000)
 		return
@@ -78,7 +91,7 @@ func (mb *client) ReadDiscreteInputs(address, quantity uint16) (results []byte,
 		FunctionCode: FuncCodeReadDiscreteInputs,
 		Data:         dataBlock(address, quantity),
 	}
-	response, err := mb.send(&request)
+	response, err := mb.send(ctx, &request)
 	if err != nil {
 		return
 	}
@@ -101,6 +114,10 @@ func (mb *client) ReadDiscreteInputs(address, quantity uint16) (results []byte,
 //  Byte count            : 1 byte
 //  Register value        : Nx2 bytes
 func (mb *client) ReadHoldingRegisters(address, quantity uint16) (results []byte, err error) {
+	return mb.ReadHoldingRegistersContext(context.Background(), address, quantity)
+}
+
+func (mb *client) ReadHoldingRegistersContext(ctx context.Context, address, quantity uint16) (results []byte, err error) {
 	if quantity < 1 || quantity > 125 {
 		err = fmt.Errorf("modbus: quantity '%v' must be between '%v' and '%v',", quantity, 1, 125)
 		return
@@ -109,7 +126,7 @@ func (mb *client) ReadHoldingRegisters(address, quantity uint16) (results []byte
 		FunctionCode: FuncCodeReadHoldingRegisters,
 		Data:         dataBlock(address, quantity),
 	}
-	response, err := mb.send(&request)
+	response, err := mb.send(ctx, &request)
 	if err != nil {
 		return
 	}
@@ -132,6 +149,10 @@ func (mb *client) ReadHoldingRegisters(address, quantity uint16) (results []byte
 //  Byte count            : 1 byte
 //  Input registers       : N bytes
 func (mb *client) ReadInputRegisters(address, quantity uint16) (results []byte, err error) {
+	return mb.ReadInputRegistersContext(context.Background(), address, quantity)
+}
+
+func (mb *client) ReadInputRegistersContext(ctx context.Context, address, quantity uint16) (results []byte, err error) {
 	if quantity < 1 || quantity > 125 {
 		err = fmt.Errorf("modbus: quantity '%v' must be between '%v' and '%v',", quantity, 1, 125)
 		return
@@ -140,7 +161,7 @@ func (mb *client) ReadInputRegisters(address, quantity uint16) (results []byte,
 		FunctionCode: FuncCodeReadInputRegisters,
 		Data:         dataBlock(address, quantity),
 	}
-	response, err := mb.send(&request)
+	response, err := mb.send(ctx, &request)
 	if err != nil {
 		return
 	}
@@ -163,6 +184,10 @@ func (mb *client) ReadInputRegisters(address, quantity uint16) (results []byte,
 //  Output address        : 2 bytes
 //  Output value          : 2 bytes
 func (mb *client) WriteSingleCoil(address, value uint16) (results []byte, err error) {
+	return mb.WriteSingleCoilContext(context.Background(), address, value)
+}
+
+func (mb *client) WriteSingleCoilContext(ctx context.Context, address, value uint16) (results []byte, err error) {
 	// The requested ON/OFF state can only be 0xFF00 and 0x0000
 	if value != 0xFF00 && value != 0x0000 {
 		err = fmt.Errorf("modbus: state '%v' must be either 0xFF00 (ON) or 0x0000 (OFF)", value)
@@ -172,7 +197,7 @@ func (mb *client) WriteSingleCoil(address, value uint16) (results []byte, err er
 		FunctionCode: FuncCodeWriteSingleCoil,
 		Data:         dataBlock(address, value),
 	}
-	response, err := mb.send(&request)
+	response, err := mb.send(ctx, &request)
 	if err != nil {
 		return
 	}
@@ -204,11 +229,15 @@ func (mb *client) WriteSingleCoil(address, value uint16) (results []byte, err er
 //  Register address      : 2 bytes
 //  Register value        : 2 bytes
 func (mb *client) WriteSingleRegister(address, value uint16) (results []byte, err error) {
+	return mb.WriteSingleRegisterContext(context.Background(), address, value)
+}
+
+func (mb *client) WriteSingleRegisterContext(ctx context.Context, address, value uint16) (results []byte, err error) {
 	request := ProtocolDataUnit{
 		FunctionCode: FuncCodeWriteSingleRegister,
 		Data:         dataBlock(address, value),
 	}
-	response, err := mb.send(&request)
+	response, err := mb.send(ctx, &request)
 	if err != nil {
 		return
 	}
@@ -242,6 +271,10 @@ func (mb *client) WriteSingleRegister(address, value uint16) (results []byte, er
 //  Starting address      : 2 bytes
 //  Quantity of outputs   : 2 bytes
 func (mb *client) WriteMultipleCoils(address, quantity uint16, value []byte) (results []byte, err error) {
+	return mb.WriteMultipleCoilsContext(context.Background(), address, quantity, value)
+}
+
+func (mb *client) WriteMultipleCoilsContext(ctx context.Context, address, quantity uint16, value []byte) (results []byte, err error) {
 	if quantity < 1 || quantity > 1968 {
 		err = fmt.Errorf("modbus: quantity '%v' must be between '%v' and '%v',", quantity, 1, 1968)
 		return
@@ -250,7 +283,7 @@ func (mb *client) WriteMultipleCoils(address, quantity uint16, value []byte) (re
 		FunctionCode: FuncCodeWriteMultipleCoils,
 		Data:         dataBlockSuffix(value, address, quantity),
 	}
-	response, err := mb.send(&request)
+	response, err := mb.send(ctx, &request)
 	if err != nil {
 		return
 	}
@@ -273,6 +306,63 @@ func (mb *client) WriteMultipleCoils(address, quantity uint16, value []byte) (re
 	return
 }
 
+// ReadCoilsBool is like ReadCoils but returns quantity status of coils.
+func (mb *client) ReadCoilsBool(address, quantity uint16) (values []bool, err error) {
+	return mb.ReadCoilsBoolContext(context.Background(), address, quantity)
+}
+
+func (mb *client) ReadCoilsBoolContext(ctx context.Context, address, quantity uint16) (values []bool, err error) {
+	results, err := mb.ReadCoilsContext(ctx, address, quantity)
+	if err != nil {
+		return
+	}
+	return unpackResults(results, quantity)
+}
+
+// ReadDiscreteInputsBool is like ReadDiscreteInputs but returns quantity
+// status of discrete inputs.
+func (mb *client) ReadDiscreteInputsBool(address, quantity uint16) (values []bool, err error) {
+	return mb.ReadDiscreteInputsBoolContext(context.Background(), address, quantity)
+}
+
+func (mb *client) ReadDiscreteInputsBoolContext(ctx context.Context, address, quantity uint16) (values []bool, err error) {
+	results, err := mb.ReadDiscreteInputsContext(ctx, address, quantity)
+	if err != nil {
+		return
+	}
+	return unpackResults(results, quantity)
+}
+
+// WriteSingleCoilBool is like WriteSingleCoil but takes the ON/OFF state
+// of the coil.
+func (mb *client) WriteSingleCoilBool(address uint16, value bool) (err error) {
+	return mb.WriteSingleCoilBoolContext(context.Background(), address, value)
+}
+
+func (mb *client) WriteSingleCoilBoolContext(ctx context.Context, address uint16, value bool) (err error) {
+	var state uint16
+	if value {
+		state = 0xFF00
+	}
+	_, err = mb.WriteSingleCoilContext(ctx, address, state)
+	return
+}
+
+// WriteMultipleCoilsBool is like WriteMultipleCoils but takes the ON/OFF
+// state of each coil.
+func (mb *client) WriteMultipleCoilsBool(address uint16, values []bool) (err error) {
+	return mb.WriteMultipleCoilsBoolContext(context.Background(), address, values)
+}
+
+func (mb *client) WriteMultipleCoilsBoolContext(ctx context.Context, address uint16, values []bool) (err error) {
+	if len(values) < 1 || len(values) > 1968 {
+		err = fmt.Errorf("modbus: quantity '%v' must be between '%v' and '%v',", len(values), 1, 1968)
+		return
+	}
+	_, err = mb.WriteMultipleCoilsContext(ctx, address, uint16(len(values)), PackBits(values))
+	return
+}
+
 // Request:
 //  Function code         : 1 byte (0x10)
 //  Starting address      : 2 bytes
@@ -284,6 +374,10 @@ func (mb *client) WriteMultipleCoils(address, quantity uint16, value []byte) (re
 //  Starting address      : 2 bytes
 //  Quantity of registers : 2 bytes
 func (mb *client) WriteMultipleRegisters(address, quantity uint16, value []byte) (results []byte, err error) {
+	return mb.WriteMultipleRegistersContext(context.Background(), address, quantity, value)
+}
+
+func (mb *client) WriteMultipleRegistersContext(ctx context.Context, address, quantity uint16, value []byte) (results []byte, err error) {
 	if quantity < 1 || quantity > 123 {
 		err = fmt.Errorf("modbus: quantity '%v' must be between '%v' and '%v',", quantity, 1, 123)
 		return
@@ -292,7 +386,7 @@ func (mb *client) WriteMultipleRegisters(address, quantity uint16, value []byte)
 		FunctionCode: FuncCodeWriteMultipleRegisters,
 		Data:         dataBlockSuffix(value, address, quantity),
 	}
-	response, err := mb.send(&request)
+	response, err := mb.send(ctx, &request)
 	if err != nil {
 		return
 	}
@@ -326,11 +420,15 @@ func (mb *client) WriteMultipleRegisters(address, quantity uint16, value []byte)
 //  AND-mask              : 2 bytes
 //  OR-mask               : 2 bytes
 func (mb *client) MaskWriteRegister(address, andMask, orMask uint16) (results []byte, err error) {
+	return mb.MaskWriteRegisterContext(context.Background(), address, andMask, orMask)
+}
+
+func (mb *client) MaskWriteRegisterContext(ctx context.Context, address, andMask, orMask uint16) (results []byte, err error) {
 	request := ProtocolDataUnit{
 		FunctionCode: FuncCodeMaskWriteRegister,
 		Data:         dataBlock(address, andMask, orMask),
 	}
-	response, err := mb.send(&request)
+	response, err := mb.send(ctx, &request)
 	if err != nil {
 		return
 	}
@@ -371,6 +469,10 @@ func (mb *client) MaskWriteRegister(address, andMask, orMask uint16) (results []
 //  Byte count            : 1 byte
 //  Read registers value  : Nx2 bytes
 func (mb *client) ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) (results []byte, err error) {
+	return mb.ReadWriteMultipleRegistersContext(context.Background(), readAddress, readQuantity, writeAddress, writeQuantity, value)
+}
+
+func (mb *client) ReadWriteMultipleRegistersContext(ctx context.Context, readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) (results []byte, err error) {
 	if readQuantity < 1 || readQuantity > 125 {
 		err = fmt.Errorf("modbus: quantity to read '%v' must be between '%v' and '%v',", readQuantity, 1, 125)
 		return
@@ -383,7 +485,7 @@ func (mb *client) ReadWriteMultipleRegisters(readAddress, readQuantity, writeAdd
 		FunctionCode: FuncCodeReadWriteMultipleRegisters,
 		Data:         dataBlockSuffix(value, readAddress, readQuantity, writeAddress, writeQuantity),
 	}
-	response, err := mb.send(&request)
+	response, err := mb.send(ctx, &request)
 	if err != nil {
 		return
 	}
@@ -406,11 +508,15 @@ func (mb *client) ReadWriteMultipleRegisters(readAddress, readQuantity, writeAdd
 //  FIFO count            : 2 bytes (<=31)
 //  FIFO value register   : Nx2 bytes
 func (mb *client) ReadFIFOQueue(address uint16) (results []byte, err error) {
+	return mb.ReadFIFOQueueContext(context.Background(), address)
+}
+
+func (mb *client) ReadFIFOQueueContext(ctx context.Context, address uint16) (results []byte, err error) {
 	request := ProtocolDataUnit{
 		FunctionCode: FuncCodeReadFIFOQueue,
 		Data:         dataBlock(address),
 	}
-	response, err := mb.send(&request)
+	response, err := mb.send(ctx, &request)
 	if err != nil {
 		return
 	}
@@ -419,8 +525,8 @@ func (mb *client) ReadFIFOQueue(address uint16) (results []byte, err error) {
 		return
 	}
 	count := int(binary.BigEndian.Uint16(response.Data))
-	if count != (len(response.Data) - 1) {
-		err = fmt.Errorf("modbus: response data size '%v' does not match count '%v'", len(response.Data)-1, count)
+	if count != (len(response.Data) - 2) {
+		err = fmt.Errorf("modbus: response data size '%v' does not match count '%v'", len(response.Data)-2, count)
 		return
 	}
 	count = int(binary.BigEndian.Uint16(response.Data[2:]))
@@ -432,15 +538,658 @@ func (mb *client) ReadFIFOQueue(address uint16) (results []byte, err error) {
 	return
 }
 
+// Request:
+//  Function code         : 1 byte (0x14)
+//  Byte count            : 1 byte
+//  Sub-requests          : N* (reference type 1 byte (0x06), file number
+//                          2 bytes, record number 2 bytes, record length
+//                          2 bytes)
+// Response:
+//  Function code         : 1 byte (0x14)
+//  Response data length  : 1 byte
+//  Sub-responses         : N* (file response length 1 byte, reference type
+//                          1 byte (0x06), record data N*2 bytes)
+func (mb *client) ReadFileRecords(records []FileRecord) (results []FileRecord, err error) {
+	return mb.ReadFileRecordsContext(context.Background(), records)
+}
+
+func (mb *client) ReadFileRecordsContext(ctx context.Context, records []FileRecord) (results []FileRecord, err error) {
+	subRequests, err := splitFileRecords(records, fileReadMaxRecordLength, false)
+	if err != nil {
+		return
+	}
+	data := make([][]byte, len(records))
+	for len(subRequests) > 0 {
+		// Sub-requests whose responses fit in a frame
+		count, size := 0, 0
+		for _, subRequest := range subRequests {
+			size += 2 + 2*int(subRequest.RecordLength)
+			if count >= fileReadMaxDataLength/7 || size > fileReadMaxDataLength {
+				break
+			}
+			count++
+		}
+		var recordData [][]byte
+		if recordData, err = mb.readFileRecords(ctx, subRequests[:count]); err != nil {
+			return
+		}
+		for i, subRequest := range subRequests[:count] {
+			data[subRequest.index] = append(data[subRequest.index], recordData[i]...)
+		}
+		subRequests = subRequests[count:]
+	}
+	results = make([]FileRecord, len(records))
+	for i, record := range records {
+		results[i] = record
+		results[i].Data = data[i]
+	}
+	return
+}
+
+// readFileRecords sends a Read File Record request of the sub-requests and
+// returns data of their records.
+func (mb *client) readFileRecords(ctx context.Context, subRequests []fileSubRequest) (results [][]byte, err error) {
+	data := []byte{byte(7 * len(subRequests))}
+	for _, subRequest := range subRequests {
+		data = append(data, fileRecordReferenceType)
+		data = append(data, dataBlock(subRequest.FileNumber, subRequest.RecordNumber, subRequest.RecordLength)...)
+	}
+	request := ProtocolDataUnit{
+		FunctionCode: FuncCodeReadFileRecord,
+		Data:         data,
+	}
+	response, err := mb.send(ctx, &request)
+	if err != nil {
+		return
+	}
+	count := int(response.Data[0])
+	length := len(response.Data) - 1
+	if count != length {
+		err = fmt.Errorf("modbus: response data size '%v' does not match count '%v'", length, count)
+		return
+	}
+	data = response.Data[1:]
+	for _, subRequest := range subRequests {
+		length = 2 + 2*int(subRequest.RecordLength)
+		if len(data) < length || int(data[0]) != length-1 || data[1] != fileRecordReferenceType {
+			err = fmt.Errorf("modbus: sub-response of file '%v' record '%v' does not match record length '%v'",
+				subRequest.FileNumber, subRequest.RecordNumber, subRequest.RecordLength)
+			return
+		}
+		results = append(results, data[2:length])
+		data = data[length:]
+	}
+	if len(data) != 0 {
+		err = fmt.Errorf("modbus: response data size '%v' is greater than expected '%v'", count, count-len(data))
+		results = nil
+	}
+	return
+}
+
+// Request:
+//  Function code         : 1 byte (0x15)
+//  Request data length   : 1 byte
+//  Sub-requests          : N* (reference type 1 byte (0x06), file number
+//                          2 bytes, record number 2 bytes, record length
+//                          2 bytes, record data N*2 bytes)
+// Response:
+//  Function code         : 1 byte (0x15)
+//  Response data length  : 1 byte
+//  Sub-requests          : echo of request
+func (mb *client) WriteFileRecords(records []FileRecord) (err error) {
+	return mb.WriteFileRecordsContext(context.Background(), records)
+}
+
+func (mb *client) WriteFileRecordsContext(ctx context.Context, records []FileRecord) (err error) {
+	subRequests, err := splitFileRecords(records, fileWriteMaxRecordLength, true)
+	if err != nil {
+		return
+	}
+	for len(subRequests) > 0 {
+		// Sub-requests which fit in a frame
+		count, size := 0, 0
+		for _, subRequest := range subRequests {
+			size += 7 + len(subRequest.Data)
+			if size > fileWriteMaxDataLength {
+				break
+			}
+			count++
+		}
+		if err = mb.writeFileRecords(ctx, subRequests[:count]); err != nil {
+			return
+		}
+		subRequests = subRequests[count:]
+	}
+	return
+}
+
+// writeFileRecords sends a Write File Record request of the sub-requests.
+func (mb *client) writeFileRecords(ctx context.Context, subRequests []fileSubRequest) (err error) {
+	data := []byte{0}
+	for _, subRequest := range subRequests {
+		data = append(data, fileRecordReferenceType)
+		data = append(data, dataBlock(subRequest.FileNumber, subRequest.RecordNumber, subRequest.RecordLength)...)
+		data = append(data, subRequest.Data...)
+	}
+	data[0] = byte(len(data) - 1)
+	request := ProtocolDataUnit{
+		FunctionCode: FuncCodeWriteFileRecord,
+		Data:         data,
+	}
+	response, err := mb.send(ctx, &request)
+	if err != nil {
+		return
+	}
+	if !bytes.Equal(data, response.Data) {
+		err = fmt.Errorf("modbus: response data '%v' does not match request '%v'", response.Data, data)
+	}
+	return
+}
+
+// ReadFile reads records of the file from record 0 until the end of the
+// file, which is detected by ExceptionCodeIllegalDataAddress, into w.
+// The exception is returned if record 0 can not be read, e.g. the file does
+// not exist.
+func (mb *client) ReadFile(fileNumber uint16, w io.Writer) (n int64, err error) {
+	return mb.ReadFileContext(context.Background(), fileNumber, w)
+}
+
+func (mb *client) ReadFileContext(ctx context.Context, fileNumber uint16, w io.Writer) (n int64, err error) {
+	if fileNumber == 0 {
+		err = fmt.Errorf("modbus: file number '%v' must be between '%v' and '%v'", fileNumber, 1, 0xFFFF)
+		return
+	}
+	recordNumber := 0
+	length := fileReadMaxRecordLength
+	for recordNumber < fileMaxRecords {
+		if length > fileMaxRecords-recordNumber {
+			length = fileMaxRecords - recordNumber
+		}
+		var results [][]byte
+		results, err = mb.readFileRecords(ctx, []fileSubRequest{{FileRecord: FileRecord{
+			FileNumber:   fileNumber,
+			RecordNumber: uint16(recordNumber),
+			RecordLength: uint16(length),
+		}}})
+		if err != nil {
+			mbError, ok := err.(*ModbusError)
+			if !ok || mbError.ExceptionCode != ExceptionCodeIllegalDataAddress {
+				return
+			}
+			// Less records remain
+			if length > 1 {
+				err = nil
+				length /= 2
+				continue
+			}
+			// End of the file, unless there is no record at all
+			if recordNumber > 0 {
+				err = nil
+			}
+			return
+		}
+		var written int
+		written, err = w.Write(results[0])
+		n += int64(written)
+		if err != nil {
+			return
+		}
+		recordNumber += length
+	}
+	return
+}
+
+// WriteFile writes data read from r until EOF to records of the file
+// starting from record 0. Data is padded with zero to a whole number of
+// records.
+func (mb *client) WriteFile(fileNumber uint16, r io.Reader) (n int64, err error) {
+	return mb.WriteFileContext(context.Background(), fileNumber, r)
+}
+
+func (mb *client) WriteFileContext(ctx context.Context, fileNumber uint16, r io.Reader) (n int64, err error) {
+	var data [2 * fileWriteMaxRecordLength]byte
+	recordNumber := 0
+	for {
+		var length int
+		length, err = io.ReadFull(r, data[:])
+		if err == io.EOF {
+			err = nil
+			return
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return
+		}
+		last := err == io.ErrUnexpectedEOF
+		records := data[:length+length%2]
+		if length%2 != 0 {
+			records[length] = 0
+		}
+		if recordNumber+len(records)/2 > fileMaxRecords {
+			err = fmt.Errorf("modbus: file size must not exceed '%v' records", fileMaxRecords)
+			return
+		}
+		subRequest := fileSubRequest{}
+		subRequest.FileNumber = fileNumber
+		subRequest.RecordNumber = uint16(recordNumber)
+		subRequest.RecordLength = uint16(len(records) / 2)
+		subRequest.Data = records
+		if err = mb.writeFileRecords(ctx, []fileSubRequest{subRequest}); err != nil {
+			return
+		}
+		n += int64(length)
+		recordNumber += len(records) / 2
+		if last {
+			return
+		}
+	}
+}
+
+// Request:
+//  Function code         : 1 byte (0x07)
+// Response:
+//  Function code         : 1 byte (0x07)
+//  Output data           : 1 byte
+func (mb *client) ReadExceptionStatus() (results []byte, err error) {
+	return mb.ReadExceptionStatusContext(context.Background())
+}
+
+func (mb *client) ReadExceptionStatusContext(ctx context.Context) (results []byte, err error) {
+	request := ProtocolDataUnit{
+		FunctionCode: FuncCodeReadExceptionStatus,
+	}
+	response, err := mb.send(ctx, &request)
+	if err != nil {
+		return
+	}
+	if len(response.Data) != 1 {
+		err = fmt.Errorf("modbus: response data size '%v' does not match expected '%v'", len(response.Data), 1)
+		return
+	}
+	results = response.Data
+	return
+}
+
+// Request:
+//  Function code         : 1 byte (0x08)
+//  Sub-function          : 2 bytes
+//  Data                  : N x 2 bytes
+// Response:
+//  Function code         : 1 byte (0x08)
+//  Sub-function          : 2 bytes
+//  Data                  : N x 2 bytes
+func (mb *client) Diagnostics(subFunction uint16, data []byte) (results []byte, err error) {
+	return mb.DiagnosticsContext(context.Background(), subFunction, data)
+}
+
+func (mb *client) DiagnosticsContext(ctx context.Context, subFunction uint16, data []byte) (results []byte, err error) {
+	if subFunction == SubFuncCodeForceListenOnlyMode {
+		err = fmt.Errorf("modbus: sub-function '%v' has no response, use ForceListenOnlyMode", subFunction)
+		return
+	}
+	request := ProtocolDataUnit{
+		FunctionCode: FuncCodeDiagnostics,
+		Data:         append(dataBlock(subFunction), data...),
+	}
+	response, err := mb.send(ctx, &request)
+	if err != nil {
+		return
+	}
+	if len(response.Data) < 2 {
+		err = fmt.Errorf("modbus: response data size '%v' is less than expected '%v'", len(response.Data), 2)
+		return
+	}
+	respValue := binary.BigEndian.Uint16(response.Data)
+	if subFunction != respValue {
+		err = fmt.Errorf("modbus: response sub-function '%v' does not match request '%v'", respValue, subFunction)
+		return
+	}
+	results = response.Data[2:]
+	return
+}
+
+func (mb *client) ReturnQueryData(data []byte) (results []byte, err error) {
+	return mb.ReturnQueryDataContext(context.Background(), data)
+}
+
+func (mb *client) ReturnQueryDataContext(ctx context.Context, data []byte) (results []byte, err error) {
+	if results, err = mb.DiagnosticsContext(ctx, SubFuncCodeReturnQueryData, data); err != nil {
+		return
+	}
+	if !bytes.Equal(data, results) {
+		err = fmt.Errorf("modbus: response data '%v' does not match request '%v'", results, data)
+	}
+	return
+}
+
+func (mb *client) RestartCommunications(clearLog bool) (err error) {
+	return mb.RestartCommunicationsContext(context.Background(), clearLog)
+}
+
+func (mb *client) RestartCommunicationsContext(ctx context.Context, clearLog bool) (err error) {
+	var value uint16
+	if clearLog {
+		value = 0xFF00
+	}
+	return mb.diagnosticsEcho(ctx, SubFuncCodeRestartCommunications, value)
+}
+
+func (mb *client) ReturnDiagnosticRegister() (value uint16, err error) {
+	return mb.ReturnDiagnosticRegisterContext(context.Background())
+}
+
+func (mb *client) ReturnDiagnosticRegisterContext(ctx context.Context) (value uint16, err error) {
+	return mb.diagnosticsValue(ctx, SubFuncCodeReturnDiagnosticRegister)
+}
+
+func (mb *client) ChangeASCIIInputDelimiter(delimiter byte) (err error) {
+	return mb.ChangeASCIIInputDelimiterContext(context.Background(), delimiter)
+}
+
+func (mb *client) ChangeASCIIInputDelimiterContext(ctx context.Context, delimiter byte) (err error) {
+	return mb.diagnosticsEcho(ctx, SubFuncCodeChangeASCIIInputDelimiter, uint16(delimiter)<<8)
+}
+
+// Request:
+//  Function code         : 1 byte (0x08)
+//  Sub-function          : 2 bytes (0x0004)
+//  Data                  : 2 bytes (0x0000)
+// No response is returned.
+func (mb *client) ForceListenOnlyMode() (err error) {
+	return mb.ForceListenOnlyModeContext(context.Background())
+}
+
+func (mb *client) ForceListenOnlyModeContext(ctx context.Context) (err error) {
+	request := ProtocolDataUnit{
+		FunctionCode: FuncCodeDiagnostics,
+		Data:         dataBlock(SubFuncCodeForceListenOnlyMode, 0),
+	}
+	return mb.sendOneWay(ctx, &request)
+}
+
+func (mb *client) ClearCounters() (err error) {
+	return mb.ClearCountersContext(context.Background())
+}
+
+func (mb *client) ClearCountersContext(ctx context.Context) (err error) {
+	return mb.diagnosticsEcho(ctx, SubFuncCodeClearCounters, 0)
+}
+
+func (mb *client) ReturnBusMessageCount() (count uint16, err error) {
+	return mb.ReturnBusMessageCountContext(context.Background())
+}
+
+func (mb *client) ReturnBusMessageCountContext(ctx context.Context) (count uint16, err error) {
+	return mb.diagnosticsValue(ctx, SubFuncCodeReturnBusMessageCount)
+}
+
+func (mb *client) ReturnBusCommunicationErrorCount() (count uint16, err error) {
+	return mb.ReturnBusCommunicationErrorCountContext(context.Background())
+}
+
+func (mb *client) ReturnBusCommunicationErrorCountContext(ctx context.Context) (count uint16, err error) {
+	return mb.diagnosticsValue(ctx, SubFuncCodeReturnBusCommunicationErrorCount)
+}
+
+func (mb *client) ReturnBusExceptionErrorCount() (count uint16, err error) {
+	return mb.ReturnBusExceptionErrorCountContext(context.Background())
+}
+
+func (mb *client) ReturnBusExceptionErrorCountContext(ctx context.Context) (count uint16, err error) {
+	return mb.diagnosticsValue(ctx, SubFuncCodeReturnBusExceptionErrorCount)
+}
+
+func (mb *client) ReturnServerMessageCount() (count uint16, err error) {
+	return mb.ReturnServerMessageCountContext(context.Background())
+}
+
+func (mb *client) ReturnServerMessageCountContext(ctx context.Context) (count uint16, err error) {
+	return mb.diagnosticsValue(ctx, SubFuncCodeReturnServerMessageCount)
+}
+
+func (mb *client) ReturnServerNoResponseCount() (count uint16, err error) {
+	return mb.ReturnServerNoResponseCountContext(context.Background())
+}
+
+func (mb *client) ReturnServerNoResponseCountContext(ctx context.Context) (count uint16, err error) {
+	return mb.diagnosticsValue(ctx, SubFuncCodeReturnServerNoResponseCount)
+}
+
+func (mb *client) ReturnServerNAKCount() (count uint16, err error) {
+	return mb.ReturnServerNAKCountContext(context.Background())
+}
+
+func (mb *client) ReturnServerNAKCountContext(ctx context.Context) (count uint16, err error) {
+	return mb.diagnosticsValue(ctx, SubFuncCodeReturnServerNAKCount)
+}
+
+func (mb *client) ReturnServerBusyCount() (count uint16, err error) {
+	return mb.ReturnServerBusyCountContext(context.Background())
+}
+
+func (mb *client) ReturnServerBusyCountContext(ctx context.Context) (count uint16, err error) {
+	return mb.diagnosticsValue(ctx, SubFuncCodeReturnServerBusyCount)
+}
+
+func (mb *client) ReturnBusCharacterOverrunCount() (count uint16, err error) {
+	return mb.ReturnBusCharacterOverrunCountContext(context.Background())
+}
+
+func (mb *client) ReturnBusCharacterOverrunCountContext(ctx context.Context) (count uint16, err error) {
+	return mb.diagnosticsValue(ctx, SubFuncCodeReturnBusCharacterOverrunCount)
+}
+
+func (mb *client) ClearOverrunCounter() (err error) {
+	return mb.ClearOverrunCounterContext(context.Background())
+}
+
+func (mb *client) ClearOverrunCounterContext(ctx context.Context) (err error) {
+	return mb.diagnosticsEcho(ctx, SubFuncCodeClearOverrunCounter, 0)
+}
+
+// Request:
+//  Function code         : 1 byte (0x0B)
+// Response:
+//  Function code         : 1 byte (0x0B)
+//  Status                : 2 bytes
+//  Event count           : 2 bytes
+func (mb *client) GetCommEventCounter() (counter *CommEventCounter, err error) {
+	return mb.GetCommEventCounterContext(context.Background())
+}
+
+func (mb *client) GetCommEventCounterContext(ctx context.Context) (counter *CommEventCounter, err error) {
+	request := ProtocolDataUnit{
+		FunctionCode: FuncCodeGetCommEventCounter,
+	}
+	response, err := mb.send(ctx, &request)
+	if err != nil {
+		return
+	}
+	if len(response.Data) != 4 {
+		err = fmt.Errorf("modbus: response data size '%v' does not match expected '%v'", len(response.Data), 4)
+		return
+	}
+	counter = &CommEventCounter{
+		Status:     binary.BigEndian.Uint16(response.Data),
+		EventCount: binary.BigEndian.Uint16(response.Data[2:]),
+	}
+	return
+}
+
+// Request:
+//  Function code         : 1 byte (0x0C)
+// Response:
+//  Function code         : 1 byte (0x0C)
+//  Byte count            : 1 byte
+//  Status                : 2 bytes
+//  Event count           : 2 bytes
+//  Message count         : 2 bytes
+//  Events                : (N-6) bytes
+func (mb *client) GetCommEventLog() (log *CommEventLog, err error) {
+	return mb.GetCommEventLogContext(context.Background())
+}
+
+func (mb *client) GetCommEventLogContext(ctx context.Context) (log *CommEventLog, err error) {
+	request := ProtocolDataUnit{
+		FunctionCode: FuncCodeGetCommEventLog,
+	}
+	response, err := mb.send(ctx, &request)
+	if err != nil {
+		return
+	}
+	count := int(response.Data[0])
+	length := len(response.Data) - 1
+	if count != length {
+		err = fmt.Errorf("modbus: response data size '%v' does not match count '%v'", length, count)
+		return
+	}
+	if count < 6 || count > 70 {
+		err = fmt.Errorf("modbus: byte count '%v' must be between '%v' and '%v'", count, 6, 70)
+		return
+	}
+	log = &CommEventLog{
+		Status:       binary.BigEndian.Uint16(response.Data[1:]),
+		EventCount:   binary.BigEndian.Uint16(response.Data[3:]),
+		MessageCount: binary.BigEndian.Uint16(response.Data[5:]),
+		Events:       response.Data[7:],
+	}
+	return
+}
+
+// Request:
+//  Function code         : 1 byte (0x11)
+// Response:
+//  Function code         : 1 byte (0x11)
+//  Byte count            : 1 byte
+//  Server ID             : device specific
+//  Run indicator status  : 1 byte (0x00 or 0xFF)
+//  Additional data       : device specific
+func (mb *client) ReportServerId() (report *ServerIdReport, err error) {
+	return mb.ReportServerIdContext(context.Background())
+}
+
+func (mb *client) ReportServerIdContext(ctx context.Context) (report *ServerIdReport, err error) {
+	request := ProtocolDataUnit{
+		FunctionCode: FuncCodeReportServerId,
+	}
+	response, err := mb.send(ctx, &request)
+	if err != nil {
+		return
+	}
+	return decodeServerIdReport(response.Data)
+}
+
+// Request:
+//  Function code         : 1 byte (0x2B)
+//  MEI type              : 1 byte (0x0E)
+//  Read device ID code   : 1 byte
+//  Object ID             : 1 byte
+// Response:
+//  Function code         : 1 byte (0x2B)
+//  MEI type              : 1 byte (0x0E)
+//  Read device ID code   : 1 byte
+//  Conformity level      : 1 byte
+//  More follows          : 1 byte (0x00 or 0xFF)
+//  Next object ID        : 1 byte
+//  Number of objects     : 1 byte
+//  Objects               : N* (ID, length and value)
+func (mb *client) ReadDeviceIdentification(readDeviceIdCode, objectId byte) (identification *DeviceIdentification, err error) {
+	return mb.ReadDeviceIdentificationContext(context.Background(), readDeviceIdCode, objectId)
+}
+
+func (mb *client) ReadDeviceIdentificationContext(ctx context.Context, readDeviceIdCode, objectId byte) (identification *DeviceIdentification, err error) {
+	result := &DeviceIdentification{Objects: make(map[byte]string)}
+	for {
+		request := ProtocolDataUnit{
+			FunctionCode: FuncCodeEncapsulatedInterfaceTransport,
+			Data:         []byte{MEITypeReadDeviceIdentification, readDeviceIdCode, objectId},
+		}
+		var response *ProtocolDataUnit
+		if response, err = mb.send(ctx, &request); err != nil {
+			return
+		}
+		var moreFollows bool
+		var nextObjectId byte
+		if moreFollows, nextObjectId, err = result.decode(readDeviceIdCode, response.Data); err != nil {
+			return
+		}
+		if !moreFollows || readDeviceIdCode == ReadDeviceIdIndividual {
+			break
+		}
+		// Next object must progress to avoid looping forever
+		if nextObjectId <= objectId {
+			err = fmt.Errorf("modbus: next object id '%v' must be greater than '%v'", nextObjectId, objectId)
+			return
+		}
+		objectId = nextObjectId
+	}
+	identification = result
+	return
+}
+
 // Helpers
 
-// send sends request and checks possible exception in the response.
-func (mb *client) send(request *ProtocolDataUnit) (response *ProtocolDataUnit, err error) {
+// diagnosticsEcho sends diagnostics request with the value which is echoed
+// in the response.
+func (mb *client) diagnosticsEcho(ctx context.Context, subFunction, value uint16) (err error) {
+	results, err := mb.DiagnosticsContext(ctx, subFunction, dataBlock(value))
+	if err != nil {
+		return
+	}
+	if len(results) != 2 || binary.BigEndian.Uint16(results) != value {
+		err = fmt.Errorf("modbus: response data '%v' does not match request '%v'", results, value)
+	}
+	return
+}
+
+// diagnosticsValue sends diagnostics request with zero data and returns
+// the value in the response.
+func (mb *client) diagnosticsValue(ctx context.Context, subFunction uint16) (value uint16, err error) {
+	results, err := mb.DiagnosticsContext(ctx, subFunction, dataBlock(0))
+	if err != nil {
+		return
+	}
+	if len(results) != 2 {
+		err = fmt.Errorf("modbus: response data size '%v' does not match expected '%v'", len(results), 2)
+		return
+	}
+	value = binary.BigEndian.Uint16(results)
+	return
+}
+
+// Send sends the request of any function code and returns the response.
+// An exception response is returned as *ModbusError.
+func (mb *client) Send(request *ProtocolDataUnit) (response *ProtocolDataUnit, err error) {
+	return mb.SendContext(context.Background(), request)
+}
+
+// SendContext is like Send but aborts when ctx is done.
+func (mb *client) SendContext(ctx context.Context, request *ProtocolDataUnit) (response *ProtocolDataUnit, err error) {
+	for attempt := 1; ; attempt++ {
+		response, err = mb.sendAttempt(ctx, request)
+		if err == nil || !mb.retry.retryable(request.FunctionCode, attempt, err) {
+			return
+		}
+		if !mb.retry.wait(ctx, attempt) {
+			return
+		}
+	}
+}
+
+// sendAttempt sends request once and checks possible exception in the response.
+func (mb *client) sendAttempt(ctx context.Context, request *ProtocolDataUnit) (response *ProtocolDataUnit, err error) {
 	aduRequest, err := mb.packager.Encode(request)
 	if err != nil {
 		return
 	}
-	aduResponse, err := mb.transporter.Send(aduRequest)
+	if b, ok := mb.packager.(broadcastPackager); ok && b.isBroadcast(aduRequest) {
+		if !isBroadcastFunction(request.FunctionCode) {
+			err = fmt.Errorf("modbus: function code '%v' must not be broadcast", request.FunctionCode)
+			return
+		}
+		err = mb.transportOneWay(ctx, aduRequest)
+		return
+	}
+	aduResponse, err := sendContext(ctx, mb.transporter, aduRequest)
 	if err != nil {
 		return
 	}
@@ -454,6 +1203,20 @@ func (mb *client) send(request *ProtocolDataUnit) (response *ProtocolDataUnit, e
 	// Check correct function code returned (exception)
 	if response.FunctionCode != request.FunctionCode {
 		err = responseError(response)
+		response = nil
+	}
+	return
+}
+
+// send sends request and checks possible exception and empty data in
+// the response.
+func (mb *client) send(ctx context.Context, request *ProtocolDataUnit) (response *ProtocolDataUnit, err error) {
+	if response, err = mb.SendContext(ctx, request); err != nil {
+		return
+	}
+	if response == nil {
+		// Broadcast is not responded, results are the values written
+		response = broadcastResponse(request)
 		return
 	}
 	if response.Data == nil || len(response.Data) == 0 {
@@ -464,6 +1227,122 @@ func (mb *client) send(request *ProtocolDataUnit) (response *ProtocolDataUnit, e
 	return
 }
 
+// sendOneWay sends request which the remote device does not respond to.
+func (mb *client) sendOneWay(ctx context.Context, request *ProtocolDataUnit) (err error) {
+	aduRequest, err := mb.packager.Encode(request)
+	if err != nil {
+		return
+	}
+	return mb.transportOneWay(ctx, aduRequest)
+}
+
+// transportOneWay sends aduRequest without waiting for the response.
+func (mb *client) transportOneWay(ctx context.Context, aduRequest []byte) (err error) {
+	transporter, ok := mb.transporter.(oneWayTransporter)
+	if !ok {
+		err = fmt.Errorf("modbus: transporter does not support requests without response")
+		return
+	}
+	return transporter.sendOneWay(ctx, aduRequest)
+}
+
+// broadcastPackager is implemented by packagers of serial line frames,
+// which can be broadcast to all devices.
+type broadcastPackager interface {
+	isBroadcast(aduRequest []byte) bool
+}
+
+// isBroadcastFunction returns true if requests of the function code can be
+// broadcast, which are writes without data in the response.
+func isBroadcastFunction(functionCode byte) bool {
+	switch functionCode {
+	case FuncCodeWriteSingleCoil, FuncCodeWriteSingleRegister,
+		FuncCodeWriteMultipleCoils, FuncCodeWriteMultipleRegisters,
+		FuncCodeMaskWriteRegister, FuncCodeWriteFileRecord:
+		return true
+	}
+	return false
+}
+
+// broadcastResponse returns the response which a device would send to
+// the request of a broadcast function.
+func broadcastResponse(request *ProtocolDataUnit) *ProtocolDataUnit {
+	response := &ProtocolDataUnit{FunctionCode: request.FunctionCode, Data: request.Data}
+	if request.FunctionCode == FuncCodeWriteMultipleCoils || request.FunctionCode == FuncCodeWriteMultipleRegisters {
+		// Starting address and quantity
+		response.Data = request.Data[:4]
+	}
+	return response
+}
+
+// Unit returns a client which sends requests to slaveId.
+func (mb *client) Unit(slaveId byte) Client {
+	return &client{
+		packager:    &unitPackager{Packager: mb.packager, slaveId: slaveId},
+		transporter: mb.transporter,
+		retry:       mb.retry,
+	}
+}
+
+// slaveEncoder is implemented by packagers which can encode requests to
+// a slave id other than the configured one.
+type slaveEncoder interface {
+	encodeSlave(slaveId byte, pdu *ProtocolDataUnit) (adu []byte, err error)
+}
+
+// unitPackager encodes requests to slaveId using Packager.
+type unitPackager struct {
+	Packager
+	slaveId byte
+}
+
+func (mb *unitPackager) Encode(pdu *ProtocolDataUnit) (adu []byte, err error) {
+	return mb.encodeSlave(mb.slaveId, pdu)
+}
+
+func (mb *unitPackager) isBroadcast(aduRequest []byte) bool {
+	b, ok := mb.Packager.(broadcastPackager)
+	return ok && b.isBroadcast(aduRequest)
+}
+
+func (mb *unitPackager) encodeSlave(slaveId byte, pdu *ProtocolDataUnit) (adu []byte, err error) {
+	encoder, ok := mb.Packager.(slaveEncoder)
+	if !ok {
+		err = fmt.Errorf("modbus: packager does not support slave id '%v'", slaveId)
+		return
+	}
+	return encoder.encodeSlave(slaveId, pdu)
+}
+
+// oneWayTransporter is implemented by transporters which can send a
+// request without waiting for the response.
+type oneWayTransporter interface {
+	sendOneWay(ctx context.Context, aduRequest []byte) (err error)
+}
+
+// sendContext sends request using SendContext if the transporter supports
+// it, otherwise ctx is only checked before sending.
+func sendContext(ctx context.Context, transporter Transporter, aduRequest []byte) (aduResponse []byte, err error) {
+	if t, ok := transporter.(ContextTransporter); ok {
+		return t.SendContext(ctx, aduRequest)
+	}
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return transporter.Send(aduRequest)
+}
+
+// unpackResults returns quantity status of bits in results of reading coils
+// or discrete inputs.
+func unpackResults(results []byte, quantity uint16) (values []bool, err error) {
+	if len(results) != (int(quantity)+7)/8 {
+		err = fmt.Errorf("modbus: response data size '%v' does not match quantity '%v'", len(results), quantity)
+		return
+	}
+	values = UnpackBits(results, int(quantity))
+	return
+}
+
 // dataBlock creates a sequence of uint16 data.
 func dataBlock(value ...uint16) []byte {
 	data := make([]byte, 2*len(value))
@@ -0,0 +1,431 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+const (
+	// Number of addresses in a table
+	dataTableMaxSize = 65536
+)
+
+// DataModel is an in-memory data model with coils, discrete inputs,
+// holding registers and input registers tables. It is safe for concurrent
+// use and implements both ServerHandler and Handler.
+type DataModel struct {
+	mu               sync.RWMutex
+	coils            dataTable
+	discreteInputs   dataTable
+	holdingRegisters dataTable
+	inputRegisters   dataTable
+	exceptionStatus  byte
+}
+
+// NewDataModel allocates a data model whose tables have the given number
+// of addresses (at most 65536) starting from 0.
+func NewDataModel(coils, discreteInputs, holdingRegisters, inputRegisters int) *DataModel {
+	m := &DataModel{}
+	m.coils.values = make([]uint16, tableSize(coils))
+	m.discreteInputs.values = make([]uint16, tableSize(discreteInputs))
+	m.holdingRegisters.values = make([]uint16, tableSize(holdingRegisters))
+	m.inputRegisters.values = make([]uint16, tableSize(inputRegisters))
+	return m
+}
+
+// NewSparseDataModel allocates a data model without any address.
+// Addresses are added with MapCoils, MapDiscreteInputs, MapHoldingRegisters
+// and MapInputRegisters.
+func NewSparseDataModel() *DataModel {
+	m := &DataModel{}
+	m.coils.mapped = []bool{}
+	m.discreteInputs.mapped = []bool{}
+	m.holdingRegisters.mapped = []bool{}
+	m.inputRegisters.mapped = []bool{}
+	return m
+}
+
+// MapCoils adds quantity coils starting at address to a sparse data model.
+func (m *DataModel) MapCoils(address, quantity uint16) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.coils.mapAddresses(address, quantity)
+}
+
+// MapDiscreteInputs adds quantity discrete inputs starting at address
+// to a sparse data model.
+func (m *DataModel) MapDiscreteInputs(address, quantity uint16) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.discreteInputs.mapAddresses(address, quantity)
+}
+
+// MapHoldingRegisters adds quantity holding registers starting at address
+// to a sparse data model.
+func (m *DataModel) MapHoldingRegisters(address, quantity uint16) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.holdingRegisters.mapAddresses(address, quantity)
+}
+
+// MapInputRegisters adds quantity input registers starting at address
+// to a sparse data model.
+func (m *DataModel) MapInputRegisters(address, quantity uint16) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.inputRegisters.mapAddresses(address, quantity)
+}
+
+// Coils returns quantity coils starting at address.
+func (m *DataModel) Coils(address, quantity uint16) ([]bool, error) {
+	return m.getBits(&m.coils, address, quantity)
+}
+
+// SetCoils sets coils starting at address.
+func (m *DataModel) SetCoils(address uint16, values []bool) error {
+	return m.setBits(&m.coils, address, values)
+}
+
+// DiscreteInputs returns quantity discrete inputs starting at address.
+func (m *DataModel) DiscreteInputs(address, quantity uint16) ([]bool, error) {
+	return m.getBits(&m.discreteInputs, address, quantity)
+}
+
+// SetDiscreteInputs sets discrete inputs starting at address.
+func (m *DataModel) SetDiscreteInputs(address uint16, values []bool) error {
+	return m.setBits(&m.discreteInputs, address, values)
+}
+
+// HoldingRegisters returns quantity holding registers starting at address.
+func (m *DataModel) HoldingRegisters(address, quantity uint16) ([]uint16, error) {
+	return m.getRegisters(&m.holdingRegisters, address, quantity)
+}
+
+// SetHoldingRegisters sets holding registers starting at address.
+func (m *DataModel) SetHoldingRegisters(address uint16, values []uint16) error {
+	return m.setRegisters(&m.holdingRegisters, address, values)
+}
+
+// InputRegisters returns quantity input registers starting at address.
+func (m *DataModel) InputRegisters(address, quantity uint16) ([]uint16, error) {
+	return m.getRegisters(&m.inputRegisters, address, quantity)
+}
+
+// SetInputRegisters sets input registers starting at address.
+func (m *DataModel) SetInputRegisters(address uint16, values []uint16) error {
+	return m.setRegisters(&m.inputRegisters, address, values)
+}
+
+// ExceptionStatus returns the eight exception status outputs.
+func (m *DataModel) ExceptionStatus() byte {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.exceptionStatus
+}
+
+// SetExceptionStatus sets the eight exception status outputs returned by
+// ReadExceptionStatus.
+func (m *DataModel) SetExceptionStatus(status byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.exceptionStatus = status
+}
+
+// ServeModbus processes requests of the bit and 16-bit access functions and
+// Read Exception Status.
+func (m *DataModel) ServeModbus(slaveId byte, request *ProtocolDataUnit) (response *ProtocolDataUnit, err error) {
+	return serveHandler(m, slaveId, request)
+}
+
+// HandleModbus processes decoded requests of the bit and 16-bit access
+// functions so that the data model can be registered in a ServeMux.
+func (m *DataModel) HandleModbus(request *Request) (results []byte, err error) {
+	var exceptionCode byte
+
+	switch request.FunctionCode {
+	case FuncCodeReadCoils:
+		results, exceptionCode = m.readBits(&m.coils, request.Address, request.Quantity)
+	case FuncCodeReadDiscreteInputs:
+		results, exceptionCode = m.readBits(&m.discreteInputs, request.Address, request.Quantity)
+	case FuncCodeReadHoldingRegisters:
+		results, exceptionCode = m.readRegisters(&m.holdingRegisters, request.Address, request.Quantity)
+	case FuncCodeReadInputRegisters:
+		results, exceptionCode = m.readRegisters(&m.inputRegisters, request.Address, request.Quantity)
+	case FuncCodeWriteSingleCoil:
+		var value byte
+		if request.Value != 0 {
+			value = 1
+		}
+		exceptionCode = m.writeBits(&m.coils, request.Address, 1, []byte{value})
+	case FuncCodeWriteSingleRegister:
+		exceptionCode = m.writeRegisters(request.Address, dataBlock(request.Value))
+	case FuncCodeWriteMultipleCoils:
+		exceptionCode = m.writeBits(&m.coils, request.Address, request.Quantity, request.Values)
+	case FuncCodeWriteMultipleRegisters:
+		exceptionCode = m.writeRegisters(request.Address, request.Values)
+	case FuncCodeMaskWriteRegister:
+		exceptionCode = m.maskWriteRegister(request.Address, request.AndMask, request.OrMask)
+	case FuncCodeReadWriteMultipleRegisters:
+		results, exceptionCode = m.readWriteMultipleRegisters(request)
+	case FuncCodeReadFIFOQueue:
+		results, exceptionCode = m.readFIFOQueue(request.Address)
+	case FuncCodeReadExceptionStatus:
+		results = []byte{m.ExceptionStatus()}
+	default:
+		exceptionCode = ExceptionCodeIllegalFunction
+	}
+	if exceptionCode != 0 {
+		err = &ModbusError{FunctionCode: request.FunctionCode, ExceptionCode: exceptionCode}
+		results = nil
+	}
+	return
+}
+
+// readBits returns bits status packed into bytes.
+func (m *DataModel) readBits(table *dataTable, address, quantity uint16) (results []byte, exceptionCode byte) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !table.contains(int(address), int(quantity)) {
+		return nil, ExceptionCodeIllegalDataAddress
+	}
+	values := table.values[address : int(address)+int(quantity)]
+	results = make([]byte, (len(values)+7)/8)
+	for i, v := range values {
+		if v != 0 {
+			results[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return
+}
+
+// readRegisters returns registers value in big-endian.
+func (m *DataModel) readRegisters(table *dataTable, address, quantity uint16) (results []byte, exceptionCode byte) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !table.contains(int(address), int(quantity)) {
+		return nil, ExceptionCodeIllegalDataAddress
+	}
+	results = table.getBytes(address, quantity)
+	return
+}
+
+// writeBits sets bits from status packed into bytes.
+func (m *DataModel) writeBits(table *dataTable, address, quantity uint16, data []byte) (exceptionCode byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !table.contains(int(address), int(quantity)) {
+		return ExceptionCodeIllegalDataAddress
+	}
+	values := table.values[address : int(address)+int(quantity)]
+	for i := range values {
+		values[i] = uint16(data[i/8]>>uint(i%8)) & 1
+	}
+	return
+}
+
+// writeRegisters sets holding registers from big-endian data.
+func (m *DataModel) writeRegisters(address uint16, data []byte) (exceptionCode byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.holdingRegisters.contains(int(address), len(data)/2) {
+		return ExceptionCodeIllegalDataAddress
+	}
+	m.holdingRegisters.putBytes(address, data)
+	return
+}
+
+func (m *DataModel) maskWriteRegister(address, andMask, orMask uint16) (exceptionCode byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.holdingRegisters.contains(int(address), 1) {
+		return ExceptionCodeIllegalDataAddress
+	}
+	value := m.holdingRegisters.values[address]
+	m.holdingRegisters.values[address] = (value & andMask) | (orMask &^ andMask)
+	return
+}
+
+func (m *DataModel) readWriteMultipleRegisters(request *Request) (results []byte, exceptionCode byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.holdingRegisters.contains(int(request.Address), int(request.Quantity)) ||
+		!m.holdingRegisters.contains(int(request.WriteAddress), int(request.WriteQuantity)) {
+		return nil, ExceptionCodeIllegalDataAddress
+	}
+	// Write operation is performed before read
+	m.holdingRegisters.putBytes(request.WriteAddress, request.Values)
+	results = m.holdingRegisters.getBytes(request.Address, request.Quantity)
+	return
+}
+
+// readFIFOQueue returns registers in the queue. The holding register at
+// FIFO pointer address contains the number of registers in the queue
+// which follow it.
+func (m *DataModel) readFIFOQueue(address uint16) (results []byte, exceptionCode byte) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !m.holdingRegisters.contains(int(address), 1) {
+		return nil, ExceptionCodeIllegalDataAddress
+	}
+	count := m.holdingRegisters.values[address]
+	if count > 31 {
+		return nil, ExceptionCodeIllegalDataValue
+	}
+	if !m.holdingRegisters.contains(int(address)+1, int(count)) {
+		return nil, ExceptionCodeIllegalDataAddress
+	}
+	results = make([]byte, 2*int(count))
+	for i, v := range m.holdingRegisters.values[int(address)+1 : int(address)+1+int(count)] {
+		binary.BigEndian.PutUint16(results[2*i:], v)
+	}
+	return
+}
+
+func (m *DataModel) getBits(table *dataTable, address, quantity uint16) (values []bool, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !table.contains(int(address), int(quantity)) {
+		err = &ModbusError{ExceptionCode: ExceptionCodeIllegalDataAddress}
+		return
+	}
+	values = make([]bool, quantity)
+	for i, v := range table.values[address : int(address)+int(quantity)] {
+		values[i] = v != 0
+	}
+	return
+}
+
+func (m *DataModel) setBits(table *dataTable, address uint16, values []bool) (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !table.contains(int(address), len(values)) {
+		err = &ModbusError{ExceptionCode: ExceptionCodeIllegalDataAddress}
+		return
+	}
+	for i, v := range values {
+		if v {
+			table.values[int(address)+i] = 1
+		} else {
+			table.values[int(address)+i] = 0
+		}
+	}
+	return
+}
+
+func (m *DataModel) getRegisters(table *dataTable, address, quantity uint16) (values []uint16, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !table.contains(int(address), int(quantity)) {
+		err = &ModbusError{ExceptionCode: ExceptionCodeIllegalDataAddress}
+		return
+	}
+	values = make([]uint16, quantity)
+	copy(values, table.values[address:])
+	return
+}
+
+func (m *DataModel) setRegisters(table *dataTable, address uint16, values []uint16) (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !table.contains(int(address), len(values)) {
+		err = &ModbusError{ExceptionCode: ExceptionCodeIllegalDataAddress}
+		return
+	}
+	copy(table.values[address:], values)
+	return
+}
+
+// dataTable holds values of a modbus table, one bit or register per value.
+// An address exists if it is less than the number of values and, in a
+// sparse table (mapped is not nil), has been mapped.
+type dataTable struct {
+	values []uint16
+	mapped []bool
+}
+
+// contains returns true if all addresses in the range exist.
+func (t *dataTable) contains(address, quantity int) bool {
+	end := address + quantity
+	if end > len(t.values) {
+		return false
+	}
+	if t.mapped != nil {
+		for _, mapped := range t.mapped[address:end] {
+			if !mapped {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// mapAddresses adds the addresses to a sparse table.
+func (t *dataTable) mapAddresses(address, quantity uint16) {
+	end := int(address) + int(quantity)
+	if t.mapped == nil {
+		// All existing addresses of a dense table are mapped
+		t.mapped = make([]bool, len(t.values))
+		for i := range t.mapped {
+			t.mapped[i] = true
+		}
+	}
+	if end > len(t.values) {
+		values := make([]uint16, end)
+		copy(values, t.values)
+		t.values = values
+		mapped := make([]bool, end)
+		copy(mapped, t.mapped)
+		t.mapped = mapped
+	}
+	for i := int(address); i < end; i++ {
+		t.mapped[i] = true
+	}
+}
+
+// getBytes returns registers value in big-endian.
+func (t *dataTable) getBytes(address, quantity uint16) []byte {
+	data := make([]byte, 2*int(quantity))
+	for i, v := range t.values[address : int(address)+int(quantity)] {
+		binary.BigEndian.PutUint16(data[2*i:], v)
+	}
+	return data
+}
+
+// putBytes sets registers from big-endian data.
+func (t *dataTable) putBytes(address uint16, data []byte) {
+	for i := 0; i+1 < len(data); i += 2 {
+		t.values[int(address)+i/2] = binary.BigEndian.Uint16(data[i:])
+	}
+}
+
+// tableSize limits size to the number of addresses in a table.
+func tableSize(size int) int {
+	if size < 0 {
+		return 0
+	}
+	if size > dataTableMaxSize {
+		return dataTableMaxSize
+	}
+	return size
+}
@@ -0,0 +1,120 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"testing"
+)
+
+var dataModelTests = []struct {
+	request  ProtocolDataUnit
+	response ProtocolDataUnit
+}{
+	// Read coils 1-11
+	{ProtocolDataUnit{1, []byte{0, 1, 0, 11}}, ProtocolDataUnit{1, []byte{2, 0x05, 0x04}}},
+	// Read discrete inputs out of range
+	{ProtocolDataUnit{2, []byte{0, 8, 0, 9}}, ProtocolDataUnit{0x82, []byte{2}}},
+	// Read holding registers, invalid quantity
+	{ProtocolDataUnit{3, []byte{0, 0, 0, 126}}, ProtocolDataUnit{0x83, []byte{3}}},
+	// Read input registers 2-3
+	{ProtocolDataUnit{4, []byte{0, 2, 0, 2}}, ProtocolDataUnit{4, []byte{4, 0, 3, 0, 4}}},
+	// Write single coil, invalid value
+	{ProtocolDataUnit{5, []byte{0, 2, 0xFF, 1}}, ProtocolDataUnit{0x85, []byte{3}}},
+	// Write single register
+	{ProtocolDataUnit{6, []byte{0, 5, 0x12, 0x34}}, ProtocolDataUnit{6, []byte{0, 5, 0x12, 0x34}}},
+	// Write multiple coils, wrong byte count
+	{ProtocolDataUnit{15, []byte{0, 0, 0, 9, 1, 0xFF}}, ProtocolDataUnit{0x8F, []byte{3}}},
+	// Mask write register 4
+	{ProtocolDataUnit{22, []byte{0, 4, 0x00, 0xF2, 0x00, 0x25}}, ProtocolDataUnit{22, []byte{0, 4, 0x00, 0xF2, 0x00, 0x25}}},
+	// Read holding register 4
+	{ProtocolDataUnit{3, []byte{0, 4, 0, 1}}, ProtocolDataUnit{3, []byte{2, 0x00, 0x17}}},
+	// Unsupported function
+	{ProtocolDataUnit{0x41, []byte{0}}, ProtocolDataUnit{0xC1, []byte{1}}},
+}
+
+func TestDataModel(t *testing.T) {
+	m := NewDataModel(16, 16, 16, 16)
+	m.SetCoils(1, []bool{true, false, true, false, false, false, false, false, false, false, true})
+	m.SetInputRegisters(2, []uint16{3, 4})
+	m.SetHoldingRegisters(4, []uint16{0x12})
+
+	for _, test := range dataModelTests {
+		response := serve(m, 1, &test.request)
+		if response.FunctionCode != test.response.FunctionCode || !bytes.Equal(response.Data, test.response.Data) {
+			t.Errorf("request %v: expected %v, actual %v", test.request, test.response, *response)
+		}
+	}
+}
+
+func TestSparseDataModel(t *testing.T) {
+	m := NewSparseDataModel()
+	m.MapHoldingRegisters(100, 2)
+	m.MapHoldingRegisters(200, 2)
+
+	if err := m.SetHoldingRegisters(100, []uint16{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetHoldingRegisters(101, []uint16{1, 2}); err == nil {
+		t.Fatal("error expected")
+	}
+	if _, err := m.HoldingRegisters(0, 1); err == nil {
+		t.Fatal("error expected")
+	}
+	values, err := m.HoldingRegisters(100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values[0] != 1 || values[1] != 2 {
+		t.Fatalf("unexpected values: %v", values)
+	}
+	if _, err = m.Coils(0, 1); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
+func TestDataModelClient(t *testing.T) {
+	m := NewDataModel(100, 100, 100, 100)
+	s := NewTCPServer("", m)
+	defer s.Close()
+	h := NewTCPClientHandler(startTCPServer(t, s))
+	defer h.Close()
+	client := NewClient(h)
+
+	if _, err := client.WriteMultipleCoils(10, 10, []byte{0xFF, 0x02}); err != nil {
+		t.Fatal(err)
+	}
+	results, err := client.ReadCoils(9, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0xFE, 0x05}, results) {
+		t.Fatalf("unexpected results: %v", results)
+	}
+	if _, err = client.WriteSingleCoil(9, 0xFF00); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.WriteMultipleRegisters(0, 4, []byte{0, 2, 0, 7, 0, 8, 0, 9}); err != nil {
+		t.Fatal(err)
+	}
+	results, err = client.ReadFIFOQueue(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0, 7, 0, 8}, results) {
+		t.Fatalf("unexpected results: %v", results)
+	}
+	results, err = client.ReadWriteMultipleRegisters(2, 2, 3, 1, []byte{0, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0, 8, 0, 1}, results) {
+		t.Fatalf("unexpected results: %v", results)
+	}
+	_, err = client.ReadInputRegisters(99, 2)
+	if mbError, ok := err.(*ModbusError); !ok || mbError.ExceptionCode != ExceptionCodeIllegalDataAddress {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
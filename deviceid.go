@@ -0,0 +1,181 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	// Maximum size of objects in a Read Device Identification response,
+	// which is the maximum PDU size minus function code, MEI type, read
+	// device id code, conformity level, more follows, next object id and
+	// number of objects.
+	deviceIdMaxObjectsSize = 253 - 7
+	deviceIdMoreFollows    = 0xFF
+	// Conformity level flag of individual access support
+	deviceIdIndividualAccess = 0x80
+)
+
+// DeviceIdentification is the result of Read Device Identification.
+//
+// DeviceIdentification is also a Handler responding with its objects so
+// that servers can register it in a ServeMux for
+// FuncCodeEncapsulatedInterfaceTransport. Objects must not be modified
+// while it is being served.
+type DeviceIdentification struct {
+	// Conformity level of the device, which is derived from Objects when
+	// served
+	ConformityLevel byte
+	// Object values by object id
+	Objects map[byte]string
+}
+
+// HandleModbus responds to Read Device Identification requests.
+// Stream access of basic, regular and extended identification includes
+// objects of the lower categories.
+func (d *DeviceIdentification) HandleModbus(request *Request) (results []byte, err error) {
+	data := request.Data
+	if request.FunctionCode != FuncCodeEncapsulatedInterfaceTransport ||
+		len(data) == 0 || data[0] != MEITypeReadDeviceIdentification {
+		err = &ModbusError{FunctionCode: request.FunctionCode, ExceptionCode: ExceptionCodeIllegalFunction}
+		return
+	}
+	if len(data) != 3 {
+		err = &ModbusError{FunctionCode: request.FunctionCode, ExceptionCode: ExceptionCodeIllegalDataValue}
+		return
+	}
+	readDeviceIdCode, objectId := data[1], data[2]
+	// Last object id of the category
+	var last byte
+	switch readDeviceIdCode {
+	case ReadDeviceIdBasic:
+		last = ObjectIdMajorMinorRevision
+	case ReadDeviceIdRegular:
+		last = 0x7F
+	case ReadDeviceIdExtended:
+		last = 0xFF
+	case ReadDeviceIdIndividual:
+		value, ok := d.Objects[objectId]
+		if !ok {
+			err = &ModbusError{FunctionCode: request.FunctionCode, ExceptionCode: ExceptionCodeIllegalDataAddress}
+			return
+		}
+		if err = checkObjectSize(objectId, value); err != nil {
+			return
+		}
+		results = d.responseHeader(readDeviceIdCode)
+		results[5] = 1
+		results = append(results, objectId, byte(len(value)))
+		results = append(results, value...)
+		return
+	default:
+		err = &ModbusError{FunctionCode: request.FunctionCode, ExceptionCode: ExceptionCodeIllegalDataValue}
+		return
+	}
+	// Stream access restarts from the beginning if the object is unknown
+	if _, ok := d.Objects[objectId]; !ok || objectId > last {
+		objectId = 0
+	}
+	results = d.responseHeader(readDeviceIdCode)
+	size := 0
+	for _, id := range d.objectIds() {
+		if id < objectId {
+			continue
+		}
+		if id > last {
+			break
+		}
+		value := d.Objects[id]
+		if err = checkObjectSize(id, value); err != nil {
+			return
+		}
+		if size+2+len(value) > deviceIdMaxObjectsSize {
+			results[3] = deviceIdMoreFollows
+			results[4] = id
+			break
+		}
+		results = append(results, id, byte(len(value)))
+		results = append(results, value...)
+		results[5]++
+		size += 2 + len(value)
+	}
+	return
+}
+
+// responseHeader returns MEI type, read device id code, conformity level,
+// more follows, next object id and number of objects of the response.
+func (d *DeviceIdentification) responseHeader(readDeviceIdCode byte) []byte {
+	return []byte{MEITypeReadDeviceIdentification, readDeviceIdCode, d.conformityLevel(), 0, 0, 0}
+}
+
+// conformityLevel returns the identification category of the objects.
+func (d *DeviceIdentification) conformityLevel() byte {
+	var level byte = ReadDeviceIdBasic
+	for id := range d.Objects {
+		if id >= 0x80 {
+			level = ReadDeviceIdExtended
+			break
+		}
+		if id > ObjectIdMajorMinorRevision {
+			level = ReadDeviceIdRegular
+		}
+	}
+	return level | deviceIdIndividualAccess
+}
+
+// objectIds returns ids of the objects in ascending order.
+func (d *DeviceIdentification) objectIds() []byte {
+	ids := make([]byte, 0, len(d.Objects))
+	for id := range d.Objects {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// decode adds the objects in data of a Read Device Identification response.
+func (d *DeviceIdentification) decode(readDeviceIdCode byte, data []byte) (moreFollows bool, nextObjectId byte, err error) {
+	if len(data) < 6 {
+		err = fmt.Errorf("modbus: response data size '%v' does not meet minimum '%v'", len(data), 6)
+		return
+	}
+	if data[0] != MEITypeReadDeviceIdentification {
+		err = fmt.Errorf("modbus: response MEI type '%v' does not match request '%v'", data[0], MEITypeReadDeviceIdentification)
+		return
+	}
+	if data[1] != readDeviceIdCode {
+		err = fmt.Errorf("modbus: response read device id code '%v' does not match request '%v'", data[1], readDeviceIdCode)
+		return
+	}
+	d.ConformityLevel = data[2]
+	moreFollows = data[3] == deviceIdMoreFollows
+	nextObjectId = data[4]
+	count := int(data[5])
+	data = data[6:]
+	for i := 0; i < count; i++ {
+		if len(data) < 2 || len(data) < 2+int(data[1]) {
+			err = fmt.Errorf("modbus: response data of object '%v' is incomplete", i)
+			return
+		}
+		length := 2 + int(data[1])
+		d.Objects[data[0]] = string(data[2:length])
+		data = data[length:]
+	}
+	if len(data) != 0 {
+		err = fmt.Errorf("modbus: response data size '%v' does not match number of objects '%v'", len(data), count)
+	}
+	return
+}
+
+// checkObjectSize returns error if the object value does not fit in
+// a response.
+func checkObjectSize(id byte, value string) error {
+	if len(value) > deviceIdMaxObjectsSize-2 {
+		return fmt.Errorf("modbus: size '%v' of object '%v' must not exceed '%v'", len(value), id, deviceIdMaxObjectsSize-2)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDeviceIdentificationHandleModbus(t *testing.T) {
+	d := &DeviceIdentification{
+		Objects: map[byte]string{
+			ObjectIdVendorName:         "goburrow",
+			ObjectIdProductCode:        "MB",
+			ObjectIdMajorMinorRevision: "1.0",
+			ObjectIdProductName:        "modbus",
+		},
+	}
+	handle := func(readDeviceIdCode, objectId byte) ([]byte, error) {
+		return d.HandleModbus(&Request{
+			FunctionCode: FuncCodeEncapsulatedInterfaceTransport,
+			Data:         []byte{MEITypeReadDeviceIdentification, readDeviceIdCode, objectId},
+		})
+	}
+
+	results, err := handle(ReadDeviceIdBasic, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{0x0E, 1, 0x82, 0, 0, 3,
+		0, 8, 'g', 'o', 'b', 'u', 'r', 'r', 'o', 'w', 1, 2, 'M', 'B', 2, 3, '1', '.', '0'}
+	if !bytes.Equal(expected, results) {
+		t.Fatalf("unexpected results: %v", results)
+	}
+	// Unknown object id restarts the stream
+	results, err = handle(ReadDeviceIdRegular, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[5] != 4 {
+		t.Fatalf("unexpected results: %v", results)
+	}
+	results, err = handle(ReadDeviceIdIndividual, ObjectIdProductName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []byte{0x0E, 4, 0x82, 0, 0, 1, 4, 6, 'm', 'o', 'd', 'b', 'u', 's'}
+	if !bytes.Equal(expected, results) {
+		t.Fatalf("unexpected results: %v", results)
+	}
+	_, err = handle(ReadDeviceIdIndividual, ObjectIdModelName)
+	if mbError, ok := err.(*ModbusError); !ok || mbError.ExceptionCode != ExceptionCodeIllegalDataAddress {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = handle(5, 0)
+	if mbError, ok := err.(*ModbusError); !ok || mbError.ExceptionCode != ExceptionCodeIllegalDataValue {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Objects which do not fit are in next responses
+	d.Objects[0x80] = strings.Repeat("x", 220)
+	results, err = handle(ReadDeviceIdExtended, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[2] != 0x83 || results[3] != 0xFF || results[4] != 0x80 || results[5] != 4 {
+		t.Fatalf("unexpected results: %v", results)
+	}
+}
+
+func TestReadDeviceIdentification(t *testing.T) {
+	objects := map[byte]string{
+		ObjectIdVendorName:          "goburrow",
+		ObjectIdProductCode:         "MB",
+		ObjectIdMajorMinorRevision:  "1.0",
+		ObjectIdUserApplicationName: "test",
+		0x80:                        strings.Repeat("x", 200),
+		0x81:                        strings.Repeat("y", 200),
+	}
+	mux := NewServeMux()
+	mux.Handle(FuncCodeEncapsulatedInterfaceTransport, &DeviceIdentification{Objects: objects})
+
+	serverPort, clientPort := net.Pipe()
+	defer clientPort.Close()
+	s := NewRTUServer("", 1, mux)
+	defer s.Close()
+	go s.Serve(serverPort)
+
+	h := NewRTUClientHandler("")
+	h.SlaveId = 1
+	h.port = clientPort
+	client := NewClient(h)
+
+	identification, err := client.ReadDeviceIdentification(ReadDeviceIdExtended, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identification.ConformityLevel != 0x83 || len(identification.Objects) != len(objects) {
+		t.Fatalf("unexpected identification: %+v", identification)
+	}
+	for id, value := range objects {
+		if identification.Objects[id] != value {
+			t.Fatalf("object %v: expected %q, actual %q", id, value, identification.Objects[id])
+		}
+	}
+	identification, err = client.ReadDeviceIdentification(ReadDeviceIdIndividual, ObjectIdUserApplicationName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identification.Objects) != 1 || identification.Objects[ObjectIdUserApplicationName] != "test" {
+		t.Fatalf("unexpected identification: %+v", identification)
+	}
+}
+
+func TestReadRTUDeviceIdentification(t *testing.T) {
+	aduRequest := []byte{1, 0x2B, 0x0E, 1, 0, 0x70, 0x77}
+	// Response with 2 objects, which is read byte by byte
+	aduResponse := []byte{1, 0x2B, 0x0E, 1, 0x81, 0, 0, 2, 0, 1, 'a', 1, 2, 'b', 'c', 0x12, 0x34}
+	var data [rtuMaxSize]byte
+	actual, err := readRTUResponse(iotest.OneByteReader(bytes.NewReader(aduResponse)), aduRequest, data[:], calculateResponseLength(aduRequest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(aduResponse, actual) {
+		t.Fatalf("unexpected response: % x", actual)
+	}
+}
+
+func TestDeviceIdentificationDecode(t *testing.T) {
+	tests := [][]byte{
+		{0x0E, 1, 0x81, 0, 0},
+		{0x0D, 1, 0x81, 0, 0, 0},
+		{0x0E, 2, 0x81, 0, 0, 0},
+		{0x0E, 1, 0x81, 0, 0, 1, 0, 2, 'a'},
+		{0x0E, 1, 0x81, 0, 0, 1, 0, 1, 'a', 'b'},
+	}
+	for _, data := range tests {
+		d := &DeviceIdentification{Objects: make(map[byte]string)}
+		if _, _, err := d.decode(ReadDeviceIdBasic, data); err == nil {
+			t.Errorf("%v: error expected", data)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+const (
+	// Reference type of file record sub-requests
+	fileRecordReferenceType = 6
+	// Number of records in a file, numbered from 0 to 9999
+	fileMaxRecords = 10000
+
+	// Maximum byte count of Read File Record requests and responses
+	fileReadMaxDataLength = 0xF5
+	// Maximum byte count of Write File Record requests and responses
+	fileWriteMaxDataLength = 0xFB
+	// Maximum record length of a read sub-request, whose response has
+	// file response length and reference type
+	fileReadMaxRecordLength = (fileReadMaxDataLength - 2) / 2
+	// Maximum record length of a write sub-request, which has reference
+	// type, file number, record number and record length
+	fileWriteMaxRecordLength = (fileWriteMaxDataLength - 7) / 2
+)
+
+// FileRecord is a group of consecutive records of a file.
+type FileRecord struct {
+	FileNumber   uint16
+	RecordNumber uint16
+	// Number of 16-bit records to read, ignored when writing
+	RecordLength uint16
+	// Record data, 2 bytes per record
+	Data []byte
+}
+
+// FileStore provides records of files to FileHandler.
+// Errors of type *ModbusError are sent back to the client as exceptions.
+type FileStore interface {
+	// ReadFileRecords returns data of length records starting from
+	// recordNumber in the file.
+	ReadFileRecords(fileNumber, recordNumber, recordLength uint16) (data []byte, err error)
+	// WriteFileRecords writes data, 2 bytes per record, starting from
+	// recordNumber in the file.
+	WriteFileRecords(fileNumber, recordNumber uint16, data []byte) (err error)
+}
+
+// FileHandler is a Handler of Read File Record and Write File Record
+// requests, whose records are read from and written to Store.
+type FileHandler struct {
+	Store FileStore
+}
+
+// NewFileHandler allocates a new FileHandler.
+func NewFileHandler(store FileStore) *FileHandler {
+	return &FileHandler{Store: store}
+}
+
+// HandleModbus processes Read File Record and Write File Record requests.
+func (h *FileHandler) HandleModbus(request *Request) (results []byte, err error) {
+	var exceptionCode byte
+
+	switch request.FunctionCode {
+	case FuncCodeReadFileRecord:
+		results, exceptionCode, err = h.readFileRecords(request.Data[1:])
+	case FuncCodeWriteFileRecord:
+		exceptionCode, err = h.writeFileRecords(request.Data[1:])
+		results = request.Data
+	default:
+		exceptionCode = ExceptionCodeIllegalFunction
+	}
+	if exceptionCode != 0 {
+		err = &ModbusError{FunctionCode: request.FunctionCode, ExceptionCode: exceptionCode}
+	}
+	if err != nil {
+		results = nil
+	}
+	return
+}
+
+// readFileRecords reads records of the sub-requests and returns the
+// response data.
+func (h *FileHandler) readFileRecords(data []byte) (results []byte, exceptionCode byte, err error) {
+	results = []byte{0}
+	for ; len(data) >= 7; data = data[7:] {
+		fileNumber := binary.BigEndian.Uint16(data[1:])
+		recordNumber := binary.BigEndian.Uint16(data[3:])
+		recordLength := binary.BigEndian.Uint16(data[5:])
+		if exceptionCode = checkFileRecord(data[0], fileNumber, recordNumber, recordLength); exceptionCode != 0 {
+			return
+		}
+		if len(results)+2+2*int(recordLength) > 1+fileReadMaxDataLength {
+			exceptionCode = ExceptionCodeIllegalDataValue
+			return
+		}
+		var records []byte
+		if records, err = h.Store.ReadFileRecords(fileNumber, recordNumber, recordLength); err != nil {
+			return
+		}
+		if len(records) != 2*int(recordLength) {
+			err = fmt.Errorf("modbus: file record size '%v' does not match expected '%v'", len(records), 2*int(recordLength))
+			return
+		}
+		results = append(results, byte(1+len(records)), fileRecordReferenceType)
+		results = append(results, records...)
+	}
+	results[0] = byte(len(results) - 1)
+	return
+}
+
+// writeFileRecords writes records of the sub-requests.
+func (h *FileHandler) writeFileRecords(data []byte) (exceptionCode byte, err error) {
+	for len(data) > 0 {
+		if len(data) < 7 {
+			exceptionCode = ExceptionCodeIllegalDataValue
+			return
+		}
+		fileNumber := binary.BigEndian.Uint16(data[1:])
+		recordNumber := binary.BigEndian.Uint16(data[3:])
+		recordLength := binary.BigEndian.Uint16(data[5:])
+		if exceptionCode = checkFileRecord(data[0], fileNumber, recordNumber, recordLength); exceptionCode != 0 {
+			return
+		}
+		length := 7 + 2*int(recordLength)
+		if len(data) < length {
+			exceptionCode = ExceptionCodeIllegalDataValue
+			return
+		}
+		if err = h.Store.WriteFileRecords(fileNumber, recordNumber, data[7:length]); err != nil {
+			return
+		}
+		data = data[length:]
+	}
+	return
+}
+
+// checkFileRecord returns the exception code if the sub-request is invalid.
+func checkFileRecord(referenceType byte, fileNumber, recordNumber, recordLength uint16) (exceptionCode byte) {
+	if referenceType != fileRecordReferenceType || fileNumber == 0 ||
+		int(recordNumber)+int(recordLength) > fileMaxRecords {
+		exceptionCode = ExceptionCodeIllegalDataAddress
+	}
+	return
+}
+
+// MemoryFileStore is a FileStore keeping files in memory.
+// It is safe for concurrent use.
+type MemoryFileStore struct {
+	mu    sync.RWMutex
+	files map[uint16][]byte
+}
+
+// NewMemoryFileStore allocates a new MemoryFileStore without any file.
+func NewMemoryFileStore() *MemoryFileStore {
+	return &MemoryFileStore{files: make(map[uint16][]byte)}
+}
+
+// File returns a copy of data of the file and whether the file exists.
+func (s *MemoryFileStore) File(fileNumber uint16) (data []byte, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	file, ok := s.files[fileNumber]
+	if ok {
+		data = append([]byte{}, file...)
+	}
+	return
+}
+
+// SetFile replaces data of the file, which is padded with zero to a whole
+// number of records.
+func (s *MemoryFileStore) SetFile(fileNumber uint16, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file := make([]byte, len(data)+len(data)%2)
+	copy(file, data)
+	s.files[fileNumber] = file
+}
+
+// ReadFileRecords returns data of the records, ExceptionCodeIllegalDataAddress
+// if the file does not exist or has less records.
+func (s *MemoryFileStore) ReadFileRecords(fileNumber, recordNumber, recordLength uint16) (data []byte, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	file, ok := s.files[fileNumber]
+	end := 2 * (int(recordNumber) + int(recordLength))
+	if !ok || end > len(file) {
+		err = &ModbusError{FunctionCode: FuncCodeReadFileRecord, ExceptionCode: ExceptionCodeIllegalDataAddress}
+		return
+	}
+	data = append([]byte{}, file[2*int(recordNumber):end]...)
+	return
+}
+
+// WriteFileRecords writes data of the records, creating the file or
+// extending it with zero records if needed.
+func (s *MemoryFileStore) WriteFileRecords(fileNumber, recordNumber uint16, data []byte) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file := s.files[fileNumber]
+	start := 2 * int(recordNumber)
+	if end := start + len(data); end > len(file) {
+		file = append(file, make([]byte, end-len(file))...)
+	}
+	copy(file[start:], data)
+	s.files[fileNumber] = file
+	return
+}
+
+// fileSubRequest is a sub-request of a file record function, which is
+// a part of the file record at index.
+type fileSubRequest struct {
+	index int
+	FileRecord
+}
+
+// splitFileRecords splits the file records into sub-requests with at most
+// maxLength records. For writing, record length is derived from the data.
+func splitFileRecords(records []FileRecord, maxLength int, write bool) (subRequests []fileSubRequest, err error) {
+	for i, record := range records {
+		length := int(record.RecordLength)
+		if write {
+			if len(record.Data)%2 != 0 {
+				err = fmt.Errorf("modbus: file record data size '%v' must be even", len(record.Data))
+				return
+			}
+			length = len(record.Data) / 2
+		}
+		if int(record.RecordNumber)+length > fileMaxRecords {
+			err = fmt.Errorf("modbus: file records '%v' to '%v' must be less than '%v'", record.RecordNumber, int(record.RecordNumber)+length, fileMaxRecords)
+			return
+		}
+		recordNumber := int(record.RecordNumber)
+		for {
+			n := length
+			if n > maxLength {
+				n = maxLength
+			}
+			subRequest := fileSubRequest{index: i}
+			subRequest.FileNumber = record.FileNumber
+			subRequest.RecordNumber = uint16(recordNumber)
+			subRequest.RecordLength = uint16(n)
+			if write {
+				subRequest.Data = record.Data[:2*n]
+				record.Data = record.Data[2*n:]
+			}
+			subRequests = append(subRequests, subRequest)
+			recordNumber += n
+			length -= n
+			if length <= 0 {
+				break
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,189 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+// newFileClient returns a client of a TCP server with file handler of the
+// store and the number of requests handled.
+func newFileClient(t *testing.T, store FileStore) (Client, *int, func()) {
+	requests := new(int)
+	handler := NewFileHandler(store)
+	counter := HandlerFunc(func(request *Request) ([]byte, error) {
+		*requests++
+		return handler.HandleModbus(request)
+	})
+	mux := NewServeMux()
+	mux.Handle(FuncCodeReadFileRecord, counter)
+	mux.Handle(FuncCodeWriteFileRecord, counter)
+	s := NewTCPServer("", mux)
+	h := NewTCPClientHandler(startTCPServer(t, s))
+	h.Timeout = time.Second
+	return NewClient(h), requests, func() {
+		h.Close()
+		s.Close()
+	}
+}
+
+func testFileData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	return data
+}
+
+func TestFileRecords(t *testing.T) {
+	store := NewMemoryFileStore()
+	client, requests, closeClient := newFileClient(t, store)
+	defer closeClient()
+
+	// 300 records need 3 write requests
+	records := []FileRecord{
+		{FileNumber: 1, RecordNumber: 0, Data: testFileData(400)},
+		{FileNumber: 2, RecordNumber: 10, Data: testFileData(200)},
+	}
+	if err := client.WriteFileRecords(records); err != nil {
+		t.Fatal(err)
+	}
+	if *requests != 3 {
+		t.Fatalf("unexpected number of requests: %v", *requests)
+	}
+	if file, _ := store.File(2); len(file) != 220 || !bytes.Equal(records[1].Data, file[20:]) {
+		t.Fatalf("unexpected file: %v", file)
+	}
+
+	*requests = 0
+	results, err := client.ReadFileRecords([]FileRecord{
+		{FileNumber: 1, RecordNumber: 0, RecordLength: 200},
+		{FileNumber: 2, RecordNumber: 10, RecordLength: 100},
+		{FileNumber: 2, RecordNumber: 0, RecordLength: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *requests != 3 {
+		t.Fatalf("unexpected number of requests: %v", *requests)
+	}
+	if len(results) != 3 || !bytes.Equal(records[0].Data, results[0].Data) ||
+		!bytes.Equal(records[1].Data, results[1].Data) || !bytes.Equal([]byte{0, 0}, results[2].Data) {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if results[1].FileNumber != 2 || results[1].RecordNumber != 10 || results[1].RecordLength != 100 {
+		t.Fatalf("unexpected results: %+v", results[1])
+	}
+
+	_, err = client.ReadFileRecords([]FileRecord{{FileNumber: 1, RecordNumber: 200, RecordLength: 1}})
+	if mbError, ok := err.(*ModbusError); !ok || mbError.ExceptionCode != ExceptionCodeIllegalDataAddress {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = client.ReadFileRecords([]FileRecord{{FileNumber: 1, RecordNumber: 9999, RecordLength: 2}})
+	if err == nil {
+		t.Fatal("error expected")
+	}
+	if err = client.WriteFileRecords([]FileRecord{{FileNumber: 1, Data: []byte{1}}}); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
+func TestFile(t *testing.T) {
+	store := NewMemoryFileStore()
+	client, requests, closeClient := newFileClient(t, store)
+	defer closeClient()
+
+	data := testFileData(1001)
+	n, err := client.WriteFile(3, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1001 {
+		t.Fatalf("unexpected size: %v", n)
+	}
+	var b bytes.Buffer
+	if n, err = client.ReadFile(3, &b); err != nil {
+		t.Fatal(err)
+	}
+	// Last record is padded
+	if n != 1002 || !bytes.Equal(append(data, 0), b.Bytes()) {
+		t.Fatalf("unexpected file: %v, %v", n, b.Bytes())
+	}
+	// File does not exist
+	b.Reset()
+	n, err = client.ReadFile(4, &b)
+	if mbError, ok := err.(*ModbusError); !ok || mbError.ExceptionCode != ExceptionCodeIllegalDataAddress || n != 0 {
+		t.Fatalf("unexpected file: %v, %v", n, err)
+	}
+	// Invalid file number is not requested
+	count := *requests
+	if n, err = client.ReadFile(0, &b); err == nil || *requests != count {
+		t.Fatalf("unexpected file: %v, %v", n, err)
+	}
+}
+
+func TestRTUFileRecords(t *testing.T) {
+	store := NewMemoryFileStore()
+	store.SetFile(1, testFileData(20))
+	mux := NewServeMux()
+	mux.Handle(FuncCodeReadFileRecord, NewFileHandler(store))
+	mux.Handle(FuncCodeWriteFileRecord, NewFileHandler(store))
+
+	serverPort, clientPort := net.Pipe()
+	defer clientPort.Close()
+	s := NewRTUServer("", 1, mux)
+	defer s.Close()
+	go s.Serve(serverPort)
+
+	h := NewRTUClientHandler("")
+	h.SlaveId = 1
+	h.port = clientPort
+	client := NewClient(h)
+
+	if err := client.WriteFileRecords([]FileRecord{{FileNumber: 1, RecordNumber: 2, Data: []byte{0xAB, 0xCD}}}); err != nil {
+		t.Fatal(err)
+	}
+	results, err := client.ReadFileRecords([]FileRecord{
+		{FileNumber: 1, RecordNumber: 0, RecordLength: 3},
+		{FileNumber: 1, RecordNumber: 9, RecordLength: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0, 1, 2, 3, 0xAB, 0xCD}, results[0].Data) || !bytes.Equal([]byte{18, 19}, results[1].Data) {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+}
+
+func TestSplitFileRecords(t *testing.T) {
+	subRequests, err := splitFileRecords([]FileRecord{
+		{FileNumber: 1, RecordNumber: 5, RecordLength: 250},
+		{FileNumber: 2, RecordNumber: 0, RecordLength: 3},
+	}, fileReadMaxRecordLength, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []fileSubRequest{
+		{0, FileRecord{FileNumber: 1, RecordNumber: 5, RecordLength: 121}},
+		{0, FileRecord{FileNumber: 1, RecordNumber: 126, RecordLength: 121}},
+		{0, FileRecord{FileNumber: 1, RecordNumber: 247, RecordLength: 8}},
+		{1, FileRecord{FileNumber: 2, RecordNumber: 0, RecordLength: 3}},
+	}
+	if len(subRequests) != len(expected) {
+		t.Fatalf("unexpected sub-requests: %+v", subRequests)
+	}
+	for i := range expected {
+		if subRequests[i].index != expected[i].index || subRequests[i].FileRecord.RecordNumber != expected[i].RecordNumber ||
+			subRequests[i].RecordLength != expected[i].RecordLength {
+			t.Fatalf("unexpected sub-request %v: %+v, expected %+v", i, subRequests[i], expected[i])
+		}
+	}
+	if _, err = splitFileRecords([]FileRecord{{RecordNumber: 9999, RecordLength: 2}}, fileReadMaxRecordLength, false); err == nil {
+		t.Fatal("error expected")
+	}
+}
@@ -0,0 +1,599 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Unmarshal reads the tagged fields of the struct pointed to by v from the
+// remote device. Fields are tagged with the table, Modicon reference number
+// and optionally the value type, byte order, scale and offset, e.g.:
+//
+//	type Meter struct {
+//		Running bool    `modbus:"coil,00001"`
+//		Alarm   bool    `modbus:"discrete,10001"`
+//		Voltage float64 `modbus:"input,30001,uint16,scale=0.1"`
+//		Power   float32 `modbus:"holding,40001,float32,cdab"`
+//		Energy  uint64  `modbus:"holding,400003,uint64,dcba"`
+//		Count   uint32  `modbus:"holding,40007,bcd32"`
+//	}
+//
+// Tables are coil, discrete, input and holding. Reference numbers have five
+// digits (e.g. 40001 to 49999) or six digits (e.g. 400001 to 465536) and
+// start from 1. Value types are int16, uint16, int32, uint32, int64, uint64,
+// float32, float64, bcd16 and bcd32, which default to the type of the field.
+// Byte orders are abcd (default), dcba, badc and cdab. Fields with scale or
+// offset must be floating point, their values are raw*scale + offset.
+//
+// Adjacent fields are read in one request, up to 2000 bits or 125
+// registers. Addresses between fields are never read as devices with sparse
+// address maps respond to them with exception IllegalDataAddress.
+func Unmarshal(client Client, v interface{}) error {
+	return UnmarshalContext(context.Background(), client, v)
+}
+
+// UnmarshalContext is like Unmarshal but aborts when ctx is done.
+func UnmarshalContext(ctx context.Context, client Client, v interface{}) (err error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("modbus: unmarshal requires a non-nil pointer to struct, got %T", v)
+	}
+	rv = rv.Elem()
+	fields, err := parseFields(rv.Type())
+	if err != nil {
+		return
+	}
+	for _, b := range groupFields(fields, false) {
+		var results []byte
+		switch b.table {
+		case FuncCodeReadCoils:
+			results, err = client.ReadCoilsContext(ctx, b.address, b.quantity)
+		case FuncCodeReadDiscreteInputs:
+			results, err = client.ReadDiscreteInputsContext(ctx, b.address, b.quantity)
+		case FuncCodeReadInputRegisters:
+			results, err = client.ReadInputRegistersContext(ctx, b.address, b.quantity)
+		case FuncCodeReadHoldingRegisters:
+			results, err = client.ReadHoldingRegistersContext(ctx, b.address, b.quantity)
+		}
+		if err != nil {
+			return
+		}
+		if err = b.checkSize(results); err != nil {
+			return
+		}
+		for _, f := range b.fields {
+			if err = f.decode(rv.FieldByIndex(f.index), results, b.address); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Marshal writes the tagged fields of the struct, or the struct pointed to,
+// by v to coils and holding registers of the remote device. Fields of
+// discrete inputs and input registers are not written. See Unmarshal for
+// the tag format.
+//
+// Adjacent fields are written in one request, up to 1968 coils or 123
+// registers. Addresses between fields are never written.
+func Marshal(client Client, v interface{}) error {
+	return MarshalContext(context.Background(), client, v)
+}
+
+// MarshalContext is like Marshal but aborts when ctx is done.
+func MarshalContext(ctx context.Context, client Client, v interface{}) (err error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("modbus: marshal requires a struct or a pointer to struct, got %T", v)
+	}
+	fields, err := parseFields(rv.Type())
+	if err != nil {
+		return
+	}
+	for _, b := range groupFields(fields, true) {
+		switch b.table {
+		case FuncCodeReadCoils:
+			values := make([]bool, b.quantity)
+			for _, f := range b.fields {
+				values[f.address-b.address] = rv.FieldByIndex(f.index).Bool()
+			}
+			err = client.WriteMultipleCoilsBoolContext(ctx, b.address, values)
+		case FuncCodeReadHoldingRegisters:
+			data := make([]byte, 2*int(b.quantity))
+			for _, f := range b.fields {
+				if err = f.encode(data[2*int(f.address-b.address):], rv.FieldByIndex(f.index)); err != nil {
+					return
+				}
+			}
+			_, err = client.WriteMultipleRegistersContext(ctx, b.address, b.quantity, data)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Number of registers of value types
+var registerValueSizes = map[string]uint16{
+	"int16":   1,
+	"uint16":  1,
+	"bcd16":   1,
+	"int32":   2,
+	"uint32":  2,
+	"float32": 2,
+	"bcd32":   2,
+	"int64":   4,
+	"uint64":  4,
+	"float64": 4,
+}
+
+// Default value types of field kinds
+var kindValueTypes = map[reflect.Kind]string{
+	reflect.Int16:   "int16",
+	reflect.Uint16:  "uint16",
+	reflect.Int32:   "int32",
+	reflect.Uint32:  "uint32",
+	reflect.Float32: "float32",
+	reflect.Int64:   "int64",
+	reflect.Uint64:  "uint64",
+	reflect.Float64: "float64",
+}
+
+// fieldMapping is a struct field mapped to coils, discrete inputs or
+// registers.
+type fieldMapping struct {
+	name  string
+	index []int
+	// Table identified by its read function code
+	table   byte
+	address uint16
+	// Number of bits or registers
+	quantity  uint16
+	valueType string
+	order     ByteOrder
+	scaled    bool
+	scale     Scale
+}
+
+// parseFields returns the mappings of tagged fields of the struct type.
+func parseFields(t reflect.Type) (fields []*fieldMapping, err error) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, ok := sf.Tag.Lookup("modbus")
+		if !ok || tag == "-" {
+			continue
+		}
+		if sf.PkgPath != "" {
+			err = fmt.Errorf("modbus: field '%v' is not exported", sf.Name)
+			return
+		}
+		var f *fieldMapping
+		if f, err = parseField(sf, tag); err != nil {
+			return
+		}
+		fields = append(fields, f)
+	}
+	return
+}
+
+// parseField parses the tag of the struct field.
+func parseField(sf reflect.StructField, tag string) (f *fieldMapping, err error) {
+	parts := strings.Split(tag, ",")
+	if len(parts) < 2 {
+		err = fmt.Errorf("modbus: tag '%v' of field '%v' must have table and address", tag, sf.Name)
+		return
+	}
+	f = &fieldMapping{name: sf.Name, index: sf.Index, scale: Scale{Factor: 1}}
+	switch parts[0] {
+	case "coil":
+		f.table = FuncCodeReadCoils
+	case "discrete":
+		f.table = FuncCodeReadDiscreteInputs
+	case "input":
+		f.table = FuncCodeReadInputRegisters
+	case "holding":
+		f.table = FuncCodeReadHoldingRegisters
+	default:
+		err = fmt.Errorf("modbus: table '%v' of field '%v' must be coil, discrete, input or holding", parts[0], sf.Name)
+		return
+	}
+	if f.address, err = referenceAddress(parts[1], f.table); err != nil {
+		err = fmt.Errorf("modbus: field '%v': %v", sf.Name, err)
+		return
+	}
+	for _, part := range parts[2:] {
+		if err = f.parseOption(part); err != nil {
+			err = fmt.Errorf("modbus: field '%v': %v", sf.Name, err)
+			return
+		}
+	}
+	kind := sf.Type.Kind()
+	if f.table == FuncCodeReadCoils || f.table == FuncCodeReadDiscreteInputs {
+		if kind != reflect.Bool || f.valueType != "" || f.scaled {
+			err = fmt.Errorf("modbus: field '%v' of bits must be bool without value type and scale", sf.Name)
+			return
+		}
+		f.quantity = 1
+		return
+	}
+	if f.valueType == "" {
+		if f.valueType = kindValueTypes[kind]; f.valueType == "" {
+			err = fmt.Errorf("modbus: value type of field '%v' must be given for %v", sf.Name, sf.Type)
+			return
+		}
+	}
+	f.quantity = registerValueSizes[f.valueType]
+	floatField := kind == reflect.Float32 || kind == reflect.Float64
+	switch {
+	case !floatField && !isIntKind(kind):
+		err = fmt.Errorf("modbus: field '%v' of registers must be a number, not %v", sf.Name, sf.Type)
+	case f.scaled && !floatField:
+		err = fmt.Errorf("modbus: field '%v' with scale or offset must be floating point", sf.Name)
+	case strings.HasPrefix(f.valueType, "float") && !floatField:
+		err = fmt.Errorf("modbus: field '%v' of %v must be floating point", sf.Name, f.valueType)
+	case int(f.address)+int(f.quantity) > dataTableMaxSize:
+		err = fmt.Errorf("modbus: registers of field '%v' exceed address '%v'", sf.Name, dataTableMaxSize-1)
+	}
+	return
+}
+
+// parseOption parses value type, byte order, scale or offset in the tag.
+func (f *fieldMapping) parseOption(option string) (err error) {
+	if i := strings.IndexByte(option, '='); i >= 0 {
+		var value float64
+		if value, err = strconv.ParseFloat(option[i+1:], 64); err != nil {
+			return
+		}
+		switch option[:i] {
+		case "scale":
+			f.scale.Factor = value
+		case "offset":
+			f.scale.Offset = value
+		default:
+			return fmt.Errorf("unknown option '%v'", option)
+		}
+		f.scaled = true
+		return
+	}
+	switch option {
+	case "abcd":
+		f.order = OrderABCD
+	case "dcba":
+		f.order = OrderDCBA
+	case "badc":
+		f.order = OrderBADC
+	case "cdab":
+		f.order = OrderCDAB
+	default:
+		if _, ok := registerValueSizes[option]; !ok {
+			return fmt.Errorf("unknown value type or byte order '%v'", option)
+		}
+		f.valueType = option
+	}
+	return
+}
+
+// referenceAddress returns the zero-based address of the Modicon reference
+// number in the table, e.g. 0 for 40001 or 400001 in holding registers.
+func referenceAddress(reference string, table byte) (address uint16, err error) {
+	var prefix byte
+	switch table {
+	case FuncCodeReadCoils:
+		prefix = '0'
+		// Leading zeros of coils are optional
+		if len(reference) < 5 {
+			reference = strings.Repeat("0", 5-len(reference)) + reference
+		}
+	case FuncCodeReadDiscreteInputs:
+		prefix = '1'
+	case FuncCodeReadInputRegisters:
+		prefix = '3'
+	case FuncCodeReadHoldingRegisters:
+		prefix = '4'
+	}
+	max := uint64(9999)
+	if len(reference) == 6 {
+		max = dataTableMaxSize
+	}
+	if (len(reference) != 5 && len(reference) != 6) || reference[0] != prefix {
+		err = fmt.Errorf("reference number '%v' must have 5 or 6 digits starting with '%c'", reference, prefix)
+		return
+	}
+	n, err := strconv.ParseUint(reference[1:], 10, 32)
+	if err != nil || n < 1 || n > max {
+		err = fmt.Errorf("reference number '%v' is out of range", reference)
+		return
+	}
+	address = uint16(n - 1)
+	return
+}
+
+// decode sets the field from results read from address.
+func (f *fieldMapping) decode(field reflect.Value, results []byte, address uint16) error {
+	offset := int(f.address - address)
+	if f.table == FuncCodeReadCoils || f.table == FuncCodeReadDiscreteInputs {
+		field.SetBool(results[offset/8]&(1<<uint(offset%8)) != 0)
+		return nil
+	}
+	b := results[2*offset:]
+	o := f.order
+	var value interface{}
+	switch f.valueType {
+	case "int16":
+		value = int64(o.Int16(b))
+	case "uint16":
+		value = uint64(o.Uint16(b))
+	case "int32":
+		value = int64(o.Int32(b))
+	case "uint32":
+		value = uint64(o.Uint32(b))
+	case "int64":
+		value = o.Int64(b)
+	case "uint64":
+		value = o.Uint64(b)
+	case "float32":
+		value = float64(o.Float32(b))
+	case "float64":
+		value = o.Float64(b)
+	case "bcd16":
+		v, err := o.BCD16(b)
+		if err != nil {
+			return err
+		}
+		value = uint64(v)
+	case "bcd32":
+		v, err := o.BCD32(b)
+		if err != nil {
+			return err
+		}
+		value = uint64(v)
+	}
+	if f.scaled {
+		value = f.scale.Value(toFloat(value))
+	}
+	return f.setNumber(field, value)
+}
+
+// setNumber sets the field to value, which is int64, uint64 or float64.
+func (f *fieldMapping) setNumber(field reflect.Value, value interface{}) error {
+	kind := field.Kind()
+	switch {
+	case kind == reflect.Float32 || kind == reflect.Float64:
+		field.SetFloat(toFloat(value))
+		return nil
+	case isSignedKind(kind):
+		var v int64
+		switch value := value.(type) {
+		case int64:
+			v = value
+		case uint64:
+			if value > math.MaxInt64 {
+				return f.overflowError(value, field)
+			}
+			v = int64(value)
+		}
+		if field.OverflowInt(v) {
+			return f.overflowError(value, field)
+		}
+		field.SetInt(v)
+	default:
+		var v uint64
+		switch value := value.(type) {
+		case int64:
+			if value < 0 {
+				return f.overflowError(value, field)
+			}
+			v = uint64(value)
+		case uint64:
+			v = value
+		}
+		if field.OverflowUint(v) {
+			return f.overflowError(value, field)
+		}
+		field.SetUint(v)
+	}
+	return nil
+}
+
+// encode puts the field value into registers data b.
+func (f *fieldMapping) encode(b []byte, field reflect.Value) (err error) {
+	o := f.order
+	var value interface{}
+	switch kind := field.Kind(); {
+	case f.scaled:
+		value = f.scale.Raw(field.Float())
+	case kind == reflect.Float32 || kind == reflect.Float64:
+		value = field.Float()
+	case isSignedKind(kind):
+		value = field.Int()
+	default:
+		value = field.Uint()
+	}
+	var signed bool
+	var min, max int64
+	var maxUnsigned uint64
+	switch f.valueType {
+	case "float32":
+		o.PutFloat32(b, float32(toFloat(value)))
+		return
+	case "float64":
+		o.PutFloat64(b, toFloat(value))
+		return
+	case "int16":
+		signed, min, max = true, math.MinInt16, math.MaxInt16
+	case "int32":
+		signed, min, max = true, math.MinInt32, math.MaxInt32
+	case "int64":
+		signed, min, max = true, math.MinInt64, math.MaxInt64
+	case "uint16":
+		maxUnsigned = math.MaxUint16
+	case "uint32":
+		maxUnsigned = math.MaxUint32
+	case "uint64":
+		maxUnsigned = math.MaxUint64
+	case "bcd16":
+		maxUnsigned = 9999
+	case "bcd32":
+		maxUnsigned = 99999999
+	}
+	// Value in two's complement
+	var u uint64
+	var inRange bool
+	switch v := value.(type) {
+	case int64:
+		u = uint64(v)
+		if signed {
+			inRange = v >= min && v <= max
+		} else {
+			inRange = v >= 0 && u <= maxUnsigned
+		}
+	case uint64:
+		u = v
+		if signed {
+			inRange = v <= uint64(max)
+		} else {
+			inRange = v <= maxUnsigned
+		}
+	case float64:
+		// Floating point values are rounded to the nearest integer
+		v = math.Round(v)
+		if signed {
+			inRange = v >= float64(min) && v < float64(max)+1
+			u = uint64(int64(v))
+		} else {
+			inRange = v >= 0 && v < float64(maxUnsigned)+1
+			u = uint64(v)
+		}
+	}
+	if !inRange {
+		return fmt.Errorf("modbus: value '%v' of field '%v' overflows %v", value, f.name, f.valueType)
+	}
+	switch f.valueType {
+	case "int16", "uint16":
+		o.PutUint16(b, uint16(u))
+	case "int32", "uint32":
+		o.PutUint32(b, uint32(u))
+	case "int64", "uint64":
+		o.PutUint64(b, u)
+	case "bcd16":
+		bcd, _ := encodeBCD(u, 4)
+		o.PutUint16(b, uint16(bcd))
+	case "bcd32":
+		bcd, _ := encodeBCD(u, 8)
+		o.PutUint32(b, uint32(bcd))
+	}
+	return
+}
+
+func (f *fieldMapping) overflowError(value interface{}, field reflect.Value) error {
+	return fmt.Errorf("modbus: value '%v' overflows field '%v' of %v", value, f.name, field.Type())
+}
+
+// fieldBlock is a range of addresses read or written in one request.
+type fieldBlock struct {
+	table    byte
+	address  uint16
+	quantity uint16
+	fields   []*fieldMapping
+}
+
+// checkSize returns error if results do not have the quantity of bits or
+// registers.
+func (b *fieldBlock) checkSize(results []byte) error {
+	size := 2 * int(b.quantity)
+	if b.table == FuncCodeReadCoils || b.table == FuncCodeReadDiscreteInputs {
+		size = (int(b.quantity) + 7) / 8
+	}
+	if len(results) != size {
+		return fmt.Errorf("modbus: response data size '%v' does not match expected '%v'", len(results), size)
+	}
+	return nil
+}
+
+// groupFields groups adjacent fields into blocks of the maximum quantity of
+// a request. When writing, only tables of coils and holding registers are
+// included.
+func groupFields(fields []*fieldMapping, write bool) (blocks []*fieldBlock) {
+	sorted := make([]*fieldMapping, 0, len(fields))
+	for _, f := range fields {
+		if !write || f.table == FuncCodeReadCoils || f.table == FuncCodeReadHoldingRegisters {
+			sorted = append(sorted, f)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].table != sorted[j].table {
+			return sorted[i].table < sorted[j].table
+		}
+		return sorted[i].address < sorted[j].address
+	})
+	var b *fieldBlock
+	for _, f := range sorted {
+		end := int(f.address) + int(f.quantity)
+		if b != nil && b.table == f.table && end-int(b.address) <= maxQuantity(f.table, write) &&
+			int(f.address) <= int(b.address)+int(b.quantity) {
+			if end > int(b.address)+int(b.quantity) {
+				b.quantity = uint16(end - int(b.address))
+			}
+			b.fields = append(b.fields, f)
+			continue
+		}
+		b = &fieldBlock{table: f.table, address: f.address, quantity: f.quantity, fields: []*fieldMapping{f}}
+		blocks = append(blocks, b)
+	}
+	return
+}
+
+// maxQuantity returns the maximum number of bits or registers in a request.
+func maxQuantity(table byte, write bool) int {
+	switch {
+	case table == FuncCodeReadCoils && write:
+		return 1968
+	case table == FuncCodeReadCoils || table == FuncCodeReadDiscreteInputs:
+		return 2000
+	case write:
+		return 123
+	}
+	return 125
+}
+
+func toFloat(value interface{}) float64 {
+	switch v := value.(type) {
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
+
+func isSignedKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+func isIntKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return isSignedKind(kind)
+}
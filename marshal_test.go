@@ -0,0 +1,237 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"math"
+	"testing"
+)
+
+type testMeter struct {
+	Running bool    `modbus:"coil,1"`
+	Alarm   bool    `modbus:"coil,00003"`
+	Tripped bool    `modbus:"discrete,10002"`
+	Voltage float64 `modbus:"input,30001,uint16,scale=0.1"`
+	Current float32 `modbus:"input,30100,int16,scale=0.01,offset=1"`
+	Power   float32 `modbus:"holding,40001,float32,cdab"`
+	Energy  uint64  `modbus:"holding,400003,dcba"`
+	Count   uint32  `modbus:"holding,40007,bcd32"`
+	Mode    int     `modbus:"holding,40009,int16"`
+	Setting int32   `modbus:"holding,40200"`
+	Ignored int
+	Skipped int `modbus:"-"`
+}
+
+// newMarshalClient returns a client of a data model and the number of
+// requests of each function code.
+func newMarshalClient(t *testing.T, m *DataModel) (Client, map[byte]int, func()) {
+	requests := make(map[byte]int)
+	mux := NewServeMux()
+	for _, functionCode := range []byte{FuncCodeReadCoils, FuncCodeReadDiscreteInputs, FuncCodeReadInputRegisters,
+		FuncCodeReadHoldingRegisters, FuncCodeWriteMultipleCoils, FuncCodeWriteMultipleRegisters} {
+		mux.HandleFunc(functionCode, func(request *Request) ([]byte, error) {
+			requests[request.FunctionCode]++
+			return m.HandleModbus(request)
+		})
+	}
+	s := NewTCPServer("", mux)
+	h := NewTCPClientHandler(startTCPServer(t, s))
+	return NewClient(h), requests, func() {
+		h.Close()
+		s.Close()
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	m := NewDataModel(10, 10, 201, 100)
+	m.SetCoils(0, []bool{true, false, true})
+	m.SetDiscreteInputs(1, []bool{true})
+	m.SetInputRegisters(0, []uint16{2305})
+	m.SetInputRegisters(99, []uint16{0xFF9C})
+	m.SetHoldingRegisters(0, []uint16{0x0000, 0x4120, 0x0807, 0x0605, 0x0403, 0x0201, 0x1234, 0x5678, 0xFFFE})
+	m.SetHoldingRegisters(199, []uint16{0xFFFF, 0xFFFD})
+	client, requests, closeClient := newMarshalClient(t, m)
+	defer closeClient()
+
+	var meter testMeter
+	if err := Unmarshal(client, &meter); err != nil {
+		t.Fatal(err)
+	}
+	expected := testMeter{
+		Running: true,
+		Alarm:   true,
+		Tripped: true,
+		Voltage: 230.5,
+		Current: 0,
+		Power:   10,
+		Energy:  0x0102030405060708,
+		Count:   12345678,
+		Mode:    -2,
+		Setting: -3,
+	}
+	if math.Abs(meter.Voltage-expected.Voltage) > 1e-9 || math.Abs(float64(meter.Current)) > 1e-6 {
+		t.Fatalf("unexpected scaled values: %+v", meter)
+	}
+	meter.Voltage, meter.Current = expected.Voltage, expected.Current
+	if meter != expected {
+		t.Fatalf("unexpected values: %+v, expected %+v", meter, expected)
+	}
+	// Fields which are not adjacent are read separately
+	if requests[FuncCodeReadCoils] != 2 || requests[FuncCodeReadDiscreteInputs] != 1 ||
+		requests[FuncCodeReadInputRegisters] != 2 || requests[FuncCodeReadHoldingRegisters] != 2 {
+		t.Fatalf("unexpected requests: %v", requests)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	m := NewDataModel(10, 10, 300, 100)
+	m.SetHoldingRegisters(9, []uint16{0xAAAA})
+	client, requests, closeClient := newMarshalClient(t, m)
+	defer closeClient()
+
+	meter := testMeter{
+		Running: true,
+		Alarm:   true,
+		Tripped: true,
+		Voltage: 1,
+		Power:   -1.5,
+		Energy:  42,
+		Count:   9876,
+		Mode:    7,
+		Setting: math.MaxInt32,
+	}
+	if err := Marshal(client, meter); err != nil {
+		t.Fatal(err)
+	}
+	var actual testMeter
+	if err := Unmarshal(client, &actual); err != nil {
+		t.Fatal(err)
+	}
+	meter.Tripped, meter.Voltage, meter.Current = false, 0, 1
+	if actual != meter {
+		t.Fatalf("unexpected values: %+v, expected %+v", actual, meter)
+	}
+	// Coils are not adjacent
+	if requests[FuncCodeWriteMultipleCoils] != 2 || requests[FuncCodeWriteMultipleRegisters] != 2 {
+		t.Fatalf("unexpected requests: %v", requests)
+	}
+	if values, _ := m.Coils(1, 1); values[0] {
+		t.Fatal("coil between fields must not be written")
+	}
+	if values, _ := m.HoldingRegisters(9, 1); values[0] != 0xAAAA {
+		t.Fatal("register between fields must not be written")
+	}
+
+	// Values must fit the registers
+	var small struct {
+		Value int `modbus:"holding,40001,uint16"`
+	}
+	small.Value = -1
+	if err := Marshal(client, &small); err == nil {
+		t.Fatal("error expected")
+	}
+	small.Value = 70000
+	if err := Marshal(client, &small); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
+func TestGroupFields(t *testing.T) {
+	var fields []*fieldMapping
+	for _, address := range []uint16{0, 100, 123, 124, 300} {
+		fields = append(fields, &fieldMapping{table: FuncCodeReadHoldingRegisters, address: address, quantity: 2})
+	}
+	// Only adjacent fields
+	for _, write := range []bool{false, true} {
+		blocks := groupFields(fields, write)
+		if len(blocks) != 4 || blocks[2].address != 123 || blocks[2].quantity != 3 {
+			t.Fatalf("unexpected blocks: %+v", blocks)
+		}
+	}
+	// Maximum quantity of a request
+	fields = fields[:0]
+	for address := uint16(0); address < 200; address += 2 {
+		fields = append(fields, &fieldMapping{table: FuncCodeReadHoldingRegisters, address: address, quantity: 2})
+	}
+	blocks := groupFields(fields, false)
+	if len(blocks) != 2 || blocks[0].quantity != 124 || blocks[1].address != 124 {
+		t.Fatalf("unexpected blocks: %+v", blocks)
+	}
+	blocks = groupFields(fields, true)
+	if len(blocks) != 2 || blocks[0].quantity != 122 || blocks[1].address != 122 {
+		t.Fatalf("unexpected blocks: %+v", blocks)
+	}
+}
+
+func TestUnmarshalSparse(t *testing.T) {
+	// Registers 1 to 9 are not mapped
+	m := NewSparseDataModel()
+	m.MapHoldingRegisters(0, 1)
+	m.MapHoldingRegisters(10, 2)
+	m.SetHoldingRegisters(0, []uint16{1})
+	m.SetHoldingRegisters(10, []uint16{2, 3})
+	client, requests, closeClient := newMarshalClient(t, m)
+	defer closeClient()
+
+	var v struct {
+		A uint16 `modbus:"holding,40001"`
+		B uint32 `modbus:"holding,40011"`
+	}
+	if err := Unmarshal(client, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.A != 1 || v.B != 0x00020003 {
+		t.Fatalf("unexpected values: %+v", v)
+	}
+	if requests[FuncCodeReadHoldingRegisters] != 2 {
+		t.Fatalf("unexpected requests: %v", requests)
+	}
+}
+
+func TestParseFieldsError(t *testing.T) {
+	tests := []interface{}{
+		&struct {
+			A bool `modbus:"coil"`
+		}{},
+		&struct {
+			A bool `modbus:"register,40001"`
+		}{},
+		&struct {
+			A uint16 `modbus:"holding,30001"`
+		}{},
+		&struct {
+			A uint16 `modbus:"holding,40000"`
+		}{},
+		&struct {
+			A uint16 `modbus:"holding,465536,uint32"`
+		}{},
+		&struct {
+			A int `modbus:"holding,40001"`
+		}{},
+		&struct {
+			A int16 `modbus:"holding,40001,scale=2"`
+		}{},
+		&struct {
+			A int32 `modbus:"holding,40001,float32"`
+		}{},
+		&struct {
+			A uint16 `modbus:"coil,1"`
+		}{},
+		&struct {
+			A uint16 `modbus:"holding,40001,abc"`
+		}{},
+		&struct {
+			a uint16 `modbus:"holding,40001"`
+		}{},
+	}
+	for _, test := range tests {
+		if err := Unmarshal(nil, test); err == nil {
+			t.Errorf("%T: error expected", test)
+		}
+	}
+	if err := Unmarshal(nil, testMeter{}); err == nil {
+		t.Error("error expected")
+	}
+}
@@ -8,6 +8,7 @@ Package modbus provides a client for MODBUS TCP and RTU/ASCII.
 package modbus
 
 import (
+	"context"
 	"fmt"
 )
 
@@ -26,6 +27,82 @@ const (
 	FuncCodeReadWriteMultipleRegisters = 23
 	FuncCodeMaskWriteRegister          = 22
 	FuncCodeReadFIFOQueue              = 24
+
+	// File record access
+	FuncCodeReadFileRecord  = 20
+	FuncCodeWriteFileRecord = 21
+
+	// Diagnostics
+	FuncCodeReadExceptionStatus = 7
+	FuncCodeDiagnostics         = 8
+	FuncCodeGetCommEventCounter = 11
+	FuncCodeGetCommEventLog     = 12
+	FuncCodeReportServerId      = 17
+
+	// Encapsulated Interface Transport
+	FuncCodeEncapsulatedInterfaceTransport = 43
+)
+
+const (
+	// MEI type of Read Device Identification
+	MEITypeReadDeviceIdentification = 0x0E
+
+	// Read device id codes
+	ReadDeviceIdBasic      = 0x01
+	ReadDeviceIdRegular    = 0x02
+	ReadDeviceIdExtended   = 0x03
+	ReadDeviceIdIndividual = 0x04
+
+	// Device identification objects. Basic objects are mandatory, regular
+	// objects are in 0x03-0x7F and extended objects in 0x80-0xFF.
+	ObjectIdVendorName          = 0x00
+	ObjectIdProductCode         = 0x01
+	ObjectIdMajorMinorRevision  = 0x02
+	ObjectIdVendorUrl           = 0x03
+	ObjectIdProductName         = 0x04
+	ObjectIdModelName           = 0x05
+	ObjectIdUserApplicationName = 0x06
+)
+
+const (
+	// Sub-function codes of Diagnostics
+	SubFuncCodeReturnQueryData                  = 0x00
+	SubFuncCodeRestartCommunications            = 0x01
+	SubFuncCodeReturnDiagnosticRegister         = 0x02
+	SubFuncCodeChangeASCIIInputDelimiter        = 0x03
+	SubFuncCodeForceListenOnlyMode              = 0x04
+	SubFuncCodeClearCounters                    = 0x0A
+	SubFuncCodeReturnBusMessageCount            = 0x0B
+	SubFuncCodeReturnBusCommunicationErrorCount = 0x0C
+	SubFuncCodeReturnBusExceptionErrorCount     = 0x0D
+	SubFuncCodeReturnServerMessageCount         = 0x0E
+	SubFuncCodeReturnServerNoResponseCount      = 0x0F
+	SubFuncCodeReturnServerNAKCount             = 0x10
+	SubFuncCodeReturnServerBusyCount            = 0x11
+	SubFuncCodeReturnBusCharacterOverrunCount   = 0x12
+	SubFuncCodeClearOverrunCounter              = 0x14
+)
+
+const (
+	// Server receive event, stored upon receipt of a request
+	CommEventReceive               = 0x80
+	CommEventReceiveCommError      = 0x02
+	CommEventReceiveOverrun        = 0x10
+	CommEventReceiveListenOnlyMode = 0x20
+	CommEventReceiveBroadcast      = 0x40
+
+	// Server send event, stored when a response is sent
+	CommEventSend               = 0x40
+	CommEventSendReadException  = 0x01
+	CommEventSendAbortException = 0x02
+	CommEventSendBusyException  = 0x04
+	CommEventSendNAKException   = 0x08
+	CommEventSendWriteTimeout   = 0x10
+	CommEventSendListenOnlyMode = 0x20
+
+	// Other events
+	CommEventEnteredListenOnlyMode = 0x04
+	CommEventRestart               = 0x00
 )
 
 const (
@@ -35,6 +112,7 @@ const (
 	ExceptionCodeServerDeviceFailure                = 4
 	ExceptionCodeAcknowledge                        = 5
 	ExceptionCodeServerDeviceBusy                   = 6
+	ExceptionCodeNegativeAcknowledge                = 7
 	ExceptionCodeMemoryParityError                  = 8
 	ExceptionCodeGatewayPathUnavailable             = 10
 	ExceptionCodeGatewayTargetDeviceFailedToRespond = 11
@@ -62,6 +140,8 @@ func (e *ModbusError) Error() string {
 		name = "acknowledge"
 	case ExceptionCodeServerDeviceBusy:
 		name = "server device busy"
+	case ExceptionCodeNegativeAcknowledge:
+		name = "negative acknowledge"
 	case ExceptionCodeMemoryParityError:
 		name = "memory parity error"
 	case ExceptionCodeGatewayPathUnavailable:
@@ -74,6 +154,34 @@ func (e *ModbusError) Error() string {
 	return fmt.Sprintf("modbus: exception '%v' (%s), function '%v'", e.ExceptionCode, name, e.FunctionCode)
 }
 
+// checksumError is returned when the CRC or LRC of a frame does not match.
+type checksumError struct {
+	name     string
+	checksum uint16
+	expected uint16
+}
+
+func (e *checksumError) Error() string {
+	return fmt.Sprintf("modbus: response %s '%v' does not match expected '%v'", e.name, e.checksum, e.expected)
+}
+
+// CommEventCounter is the response of Get Comm Event Counter.
+type CommEventCounter struct {
+	// 0xFFFF if a previous command is still being processed, 0 otherwise
+	Status     uint16
+	EventCount uint16
+}
+
+// CommEventLog is the response of Get Comm Event Log.
+type CommEventLog struct {
+	// 0xFFFF if a previous command is still being processed, 0 otherwise
+	Status       uint16
+	EventCount   uint16
+	MessageCount uint16
+	// Events in reverse chronological order, 0 to 64 bytes
+	Events []byte
+}
+
 // ProtocolDataUnit (PDU) is independent of underlying communication layers.
 type ProtocolDataUnit struct {
 	FunctionCode byte
@@ -91,3 +199,9 @@ type Packager interface {
 type Transporter interface {
 	Send(aduRequest []byte) (aduResponse []byte, err error)
 }
+
+// ContextTransporter is implemented by transporters which can abort
+// sending when the context is done.
+type ContextTransporter interface {
+	SendContext(ctx context.Context, aduRequest []byte) (aduResponse []byte, err error)
+}
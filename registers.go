@@ -0,0 +1,284 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// ByteOrder is the order of bytes of values stored in registers, named after
+// the position of bytes A, B, C and D of a 32-bit big-endian value 0xAABBCCDD.
+// 16-bit and 64-bit values are ordered likewise: the bytes within each
+// register are swapped for DCBA and BADC, and the registers are in reverse
+// order for DCBA and CDAB.
+//
+// Decoding functions panic if the data is too short, like those of
+// encoding/binary.
+type ByteOrder int
+
+const (
+	// Big-endian, like registers in the specification
+	OrderABCD ByteOrder = iota
+	// Little-endian
+	OrderDCBA
+	// Big-endian with bytes swapped in each register
+	OrderBADC
+	// Big-endian registers in reverse order (word swap)
+	OrderCDAB
+)
+
+// String returns the name of the byte order.
+func (o ByteOrder) String() string {
+	switch o {
+	case OrderABCD:
+		return "ABCD"
+	case OrderDCBA:
+		return "DCBA"
+	case OrderBADC:
+		return "BADC"
+	case OrderCDAB:
+		return "CDAB"
+	}
+	return fmt.Sprintf("ByteOrder(%d)", int(o))
+}
+
+// reorder copies src to dst, converting between the byte order and
+// big-endian. Both must have the same even length.
+func (o ByteOrder) reorder(dst, src []byte) {
+	swapBytes := o == OrderDCBA || o == OrderBADC
+	reverseWords := o == OrderDCBA || o == OrderCDAB
+	words := len(dst) / 2
+	for i := 0; i < words; i++ {
+		j := i
+		if reverseWords {
+			j = words - 1 - i
+		}
+		hi, lo := src[2*j], src[2*j+1]
+		if swapBytes {
+			hi, lo = lo, hi
+		}
+		dst[2*i], dst[2*i+1] = hi, lo
+	}
+}
+
+// Uint16 decodes a register.
+func (o ByteOrder) Uint16(b []byte) uint16 {
+	var buf [2]byte
+	o.reorder(buf[:], b[:2])
+	return binary.BigEndian.Uint16(buf[:])
+}
+
+// Int16 decodes a register.
+func (o ByteOrder) Int16(b []byte) int16 {
+	return int16(o.Uint16(b))
+}
+
+// Uint32 decodes two registers.
+func (o ByteOrder) Uint32(b []byte) uint32 {
+	var buf [4]byte
+	o.reorder(buf[:], b[:4])
+	return binary.BigEndian.Uint32(buf[:])
+}
+
+// Int32 decodes two registers.
+func (o ByteOrder) Int32(b []byte) int32 {
+	return int32(o.Uint32(b))
+}
+
+// Float32 decodes an IEEE 754 single precision value in two registers.
+func (o ByteOrder) Float32(b []byte) float32 {
+	return math.Float32frombits(o.Uint32(b))
+}
+
+// Uint64 decodes four registers.
+func (o ByteOrder) Uint64(b []byte) uint64 {
+	var buf [8]byte
+	o.reorder(buf[:], b[:8])
+	return binary.BigEndian.Uint64(buf[:])
+}
+
+// Int64 decodes four registers.
+func (o ByteOrder) Int64(b []byte) int64 {
+	return int64(o.Uint64(b))
+}
+
+// Float64 decodes an IEEE 754 double precision value in four registers.
+func (o ByteOrder) Float64(b []byte) float64 {
+	return math.Float64frombits(o.Uint64(b))
+}
+
+// BCD16 decodes a register of 4 binary-coded decimal digits.
+func (o ByteOrder) BCD16(b []byte) (uint16, error) {
+	v, err := decodeBCD(uint64(o.Uint16(b)), 4)
+	return uint16(v), err
+}
+
+// BCD32 decodes two registers of 8 binary-coded decimal digits.
+func (o ByteOrder) BCD32(b []byte) (uint32, error) {
+	v, err := decodeBCD(uint64(o.Uint32(b)), 8)
+	return uint32(v), err
+}
+
+// PutUint16 encodes v in a register.
+func (o ByteOrder) PutUint16(b []byte, v uint16) {
+	var buf [2]byte
+	binary.BigEndian.PutUint16(buf[:], v)
+	o.reorder(b[:2], buf[:])
+}
+
+// PutInt16 encodes v in a register.
+func (o ByteOrder) PutInt16(b []byte, v int16) {
+	o.PutUint16(b, uint16(v))
+}
+
+// PutUint32 encodes v in two registers.
+func (o ByteOrder) PutUint32(b []byte, v uint32) {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	o.reorder(b[:4], buf[:])
+}
+
+// PutInt32 encodes v in two registers.
+func (o ByteOrder) PutInt32(b []byte, v int32) {
+	o.PutUint32(b, uint32(v))
+}
+
+// PutFloat32 encodes v in two registers.
+func (o ByteOrder) PutFloat32(b []byte, v float32) {
+	o.PutUint32(b, math.Float32bits(v))
+}
+
+// PutUint64 encodes v in four registers.
+func (o ByteOrder) PutUint64(b []byte, v uint64) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	o.reorder(b[:8], buf[:])
+}
+
+// PutInt64 encodes v in four registers.
+func (o ByteOrder) PutInt64(b []byte, v int64) {
+	o.PutUint64(b, uint64(v))
+}
+
+// PutFloat64 encodes v in four registers.
+func (o ByteOrder) PutFloat64(b []byte, v float64) {
+	o.PutUint64(b, math.Float64bits(v))
+}
+
+// AppendUint16 appends the register of v to b, so that values can be
+// encoded for WriteMultipleRegisters.
+func (o ByteOrder) AppendUint16(b []byte, v uint16) []byte {
+	var buf [2]byte
+	o.PutUint16(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// AppendInt16 appends the register of v to b.
+func (o ByteOrder) AppendInt16(b []byte, v int16) []byte {
+	return o.AppendUint16(b, uint16(v))
+}
+
+// AppendUint32 appends the two registers of v to b.
+func (o ByteOrder) AppendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	o.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// AppendInt32 appends the two registers of v to b.
+func (o ByteOrder) AppendInt32(b []byte, v int32) []byte {
+	return o.AppendUint32(b, uint32(v))
+}
+
+// AppendFloat32 appends the two registers of v to b.
+func (o ByteOrder) AppendFloat32(b []byte, v float32) []byte {
+	return o.AppendUint32(b, math.Float32bits(v))
+}
+
+// AppendUint64 appends the four registers of v to b.
+func (o ByteOrder) AppendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	o.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// AppendInt64 appends the four registers of v to b.
+func (o ByteOrder) AppendInt64(b []byte, v int64) []byte {
+	return o.AppendUint64(b, uint64(v))
+}
+
+// AppendFloat64 appends the four registers of v to b.
+func (o ByteOrder) AppendFloat64(b []byte, v float64) []byte {
+	return o.AppendUint64(b, math.Float64bits(v))
+}
+
+// AppendBCD16 appends the register of v, which must not exceed 9999, in
+// binary-coded decimal to b.
+func (o ByteOrder) AppendBCD16(b []byte, v uint16) ([]byte, error) {
+	bcd, err := encodeBCD(uint64(v), 4)
+	if err != nil {
+		return b, err
+	}
+	return o.AppendUint16(b, uint16(bcd)), nil
+}
+
+// AppendBCD32 appends the two registers of v, which must not exceed
+// 99999999, in binary-coded decimal to b.
+func (o ByteOrder) AppendBCD32(b []byte, v uint32) ([]byte, error) {
+	bcd, err := encodeBCD(uint64(v), 8)
+	if err != nil {
+		return b, err
+	}
+	return o.AppendUint32(b, uint32(bcd)), nil
+}
+
+// decodeBCD returns the value of the binary-coded decimal digits.
+func decodeBCD(bcd uint64, digits int) (v uint64, err error) {
+	var scale uint64 = 1
+	for i := 0; i < digits; i++ {
+		digit := bcd & 0xF
+		if digit > 9 {
+			err = fmt.Errorf("modbus: invalid BCD digit '%v' in '%#x'", digit, bcd)
+			return
+		}
+		v += digit * scale
+		scale *= 10
+		bcd >>= 4
+	}
+	return
+}
+
+// encodeBCD returns the binary-coded decimal digits of v.
+func encodeBCD(v uint64, digits int) (bcd uint64, err error) {
+	value := v
+	for i := 0; i < digits; i++ {
+		bcd |= (v % 10) << (4 * uint(i))
+		v /= 10
+	}
+	if v != 0 {
+		err = fmt.Errorf("modbus: value '%v' exceeds '%v' BCD digits", value, digits)
+	}
+	return
+}
+
+// Scale converts raw register values to engineering values, which are
+// raw*Factor + Offset.
+type Scale struct {
+	Factor float64
+	Offset float64
+}
+
+// Value returns the engineering value of the raw value.
+func (s Scale) Value(raw float64) float64 {
+	return raw*s.Factor + s.Offset
+}
+
+// Raw returns the raw value of the engineering value. It needs rounding,
+// e.g. with math.Round, to be encoded in integer registers.
+func (s Scale) Raw(value float64) float64 {
+	return (value - s.Offset) / s.Factor
+}
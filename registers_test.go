@@ -0,0 +1,107 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestByteOrder32(t *testing.T) {
+	tests := []struct {
+		order ByteOrder
+		data  []byte
+	}{
+		{OrderABCD, []byte{0x41, 0x20, 0x00, 0x01}},
+		{OrderDCBA, []byte{0x01, 0x00, 0x20, 0x41}},
+		{OrderBADC, []byte{0x20, 0x41, 0x01, 0x00}},
+		{OrderCDAB, []byte{0x00, 0x01, 0x41, 0x20}},
+	}
+	for _, test := range tests {
+		if v := test.order.Uint32(test.data); v != 0x41200001 {
+			t.Errorf("%v: unexpected value %#x", test.order, v)
+		}
+		if v := test.order.AppendUint32(nil, 0x41200001); !bytes.Equal(test.data, v) {
+			t.Errorf("%v: unexpected data % x", test.order, v)
+		}
+		if v := test.order.Float32(test.order.AppendFloat32(nil, 10.5)); v != 10.5 {
+			t.Errorf("%v: unexpected value %v", test.order, v)
+		}
+		if v := test.order.Int32(test.order.AppendInt32(nil, -2)); v != -2 {
+			t.Errorf("%v: unexpected value %v", test.order, v)
+		}
+	}
+	// 10.0 in a Siemens meter
+	if v := OrderCDAB.Float32([]byte{0x00, 0x00, 0x41, 0x20}); v != 10 {
+		t.Errorf("unexpected value %v", v)
+	}
+}
+
+func TestByteOrder16And64(t *testing.T) {
+	tests := []struct {
+		order  ByteOrder
+		data16 []byte
+		data64 []byte
+	}{
+		{OrderABCD, []byte{1, 2}, []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{OrderDCBA, []byte{2, 1}, []byte{8, 7, 6, 5, 4, 3, 2, 1}},
+		{OrderBADC, []byte{2, 1}, []byte{2, 1, 4, 3, 6, 5, 8, 7}},
+		{OrderCDAB, []byte{1, 2}, []byte{7, 8, 5, 6, 3, 4, 1, 2}},
+	}
+	for _, test := range tests {
+		if v := test.order.Uint16(test.data16); v != 0x0102 {
+			t.Errorf("%v: unexpected value %#x", test.order, v)
+		}
+		if v := test.order.AppendUint16(nil, 0x0102); !bytes.Equal(test.data16, v) {
+			t.Errorf("%v: unexpected data % x", test.order, v)
+		}
+		if v := test.order.Uint64(test.data64); v != 0x0102030405060708 {
+			t.Errorf("%v: unexpected value %#x", test.order, v)
+		}
+		if v := test.order.AppendUint64(nil, 0x0102030405060708); !bytes.Equal(test.data64, v) {
+			t.Errorf("%v: unexpected data % x", test.order, v)
+		}
+		if v := test.order.Float64(test.order.AppendFloat64(nil, -1.25)); v != -1.25 {
+			t.Errorf("%v: unexpected value %v", test.order, v)
+		}
+		if v := test.order.Int64(test.order.AppendInt64(nil, -3)); v != -3 {
+			t.Errorf("%v: unexpected value %v", test.order, v)
+		}
+		if v := test.order.Int16(test.order.AppendInt16(nil, -4)); v != -4 {
+			t.Errorf("%v: unexpected value %v", test.order, v)
+		}
+	}
+}
+
+func TestBCD(t *testing.T) {
+	v16, err := OrderABCD.BCD16([]byte{0x12, 0x34})
+	if err != nil || v16 != 1234 {
+		t.Fatalf("unexpected value: %v, %v", v16, err)
+	}
+	v32, err := OrderCDAB.BCD32([]byte{0x56, 0x78, 0x12, 0x34})
+	if err != nil || v32 != 12345678 {
+		t.Fatalf("unexpected value: %v, %v", v32, err)
+	}
+	if _, err = OrderABCD.BCD16([]byte{0x12, 0x3A}); err == nil {
+		t.Fatal("error expected")
+	}
+	b, err := OrderABCD.AppendBCD32(nil, 99999999)
+	if err != nil || !bytes.Equal([]byte{0x99, 0x99, 0x99, 0x99}, b) {
+		t.Fatalf("unexpected data: % x, %v", b, err)
+	}
+	if b, err = OrderABCD.AppendBCD16(nil, 10000); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
+func TestScale(t *testing.T) {
+	s := Scale{Factor: 0.1, Offset: -40}
+	if v := s.Value(650); v != 25 {
+		t.Fatalf("unexpected value: %v", v)
+	}
+	if v := s.Raw(25); v < 649.999 || v > 650.001 {
+		t.Fatalf("unexpected raw value: %v", v)
+	}
+}
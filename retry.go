@@ -0,0 +1,139 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+// RetryPolicy configures how a client retries requests which fail with
+// transient errors.
+//
+// Only requests of read functions are retried by default, as a write may
+// have been executed by the remote device although its response was lost.
+type RetryPolicy struct {
+	// Maximum number of attempts including the first one,
+	// retrying is disabled if it is less than 2
+	MaxAttempts int
+	// Delay before the first retry, doubled for each subsequent retry
+	Backoff time.Duration
+	// Upper limit of the delay, not limited if it is zero
+	MaxBackoff time.Duration
+	// Fraction of the delay (0 to 1) which is randomly subtracted so that
+	// clients do not retry at the same time
+	Jitter float64
+	// Retry requests of write and unknown function codes as well
+	RetryWrites bool
+	// Retryable reports whether a request failed with err should be
+	// retried, IsRetryable is used if it is nil
+	Retryable func(err error) bool
+}
+
+// NewRetryClient creates a new modbus client with given backend handler
+// which retries failed requests according to policy.
+func NewRetryClient(handler ClientHandler, policy *RetryPolicy) Client {
+	return &client{packager: handler, transporter: handler, retry: policy}
+}
+
+// IsRetryable reports whether err is a transient error, after which the
+// request may succeed if it is sent again: timeouts, CRC or LRC mismatch and
+// exceptions Acknowledge, ServerDeviceBusy and gateway exceptions.
+// Other exceptions such as illegal function, data address and data value
+// are not retryable.
+func IsRetryable(err error) bool {
+	var modbusError *ModbusError
+	if errors.As(err, &modbusError) {
+		switch modbusError.ExceptionCode {
+		case ExceptionCodeAcknowledge, ExceptionCodeServerDeviceBusy,
+			ExceptionCodeGatewayPathUnavailable, ExceptionCodeGatewayTargetDeviceFailedToRespond:
+			return true
+		}
+		return false
+	}
+	var checksum *checksumError
+	if errors.As(err, &checksum) {
+		return true
+	}
+	if errors.Is(err, serial.ErrTimeout) {
+		return true
+	}
+	var netError net.Error
+	return errors.As(err, &netError) && netError.Timeout()
+}
+
+// retryable returns true if the request of function code failed with err
+// in the attempt (starting from 1) should be sent again.
+func (p *RetryPolicy) retryable(functionCode byte, attempt int, err error) bool {
+	if p == nil || attempt >= p.MaxAttempts {
+		return false
+	}
+	if !p.RetryWrites && !isReadFunction(functionCode) {
+		return false
+	}
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryable(err)
+}
+
+// wait sleeps for the backoff delay after the attempt, it returns false
+// if ctx is done before that.
+func (p *RetryPolicy) wait(ctx context.Context, attempt int) bool {
+	delay := p.backoff(attempt)
+	if delay <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// backoff returns the delay after the attempt.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.Backoff
+	for i := 1; i < attempt; i++ {
+		if delay > math.MaxInt64/2 || (p.MaxBackoff > 0 && delay >= p.MaxBackoff) {
+			break
+		}
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		jitter := p.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		delay -= time.Duration(jitter * rand.Float64() * float64(delay))
+	}
+	return delay
+}
+
+// isReadFunction returns true if requests of the function code do not change
+// the state of the remote device.
+func isReadFunction(functionCode byte) bool {
+	switch functionCode {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs,
+		FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters, FuncCodeReadFIFOQueue,
+		FuncCodeReadFileRecord, FuncCodeReadExceptionStatus,
+		FuncCodeGetCommEventCounter, FuncCodeGetCommEventLog, FuncCodeReportServerId,
+		FuncCodeEncapsulatedInterfaceTransport:
+		return true
+	}
+	return false
+}
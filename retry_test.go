@@ -0,0 +1,280 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+// startFailingTCPServer starts a server which responds with exception code
+// to the first failures requests.
+func startFailingTCPServer(t *testing.T, exceptionCode byte, failures int32) (address string, requests *int32, stop func()) {
+	requests = new(int32)
+	handler := ServerHandlerFunc(func(slaveId byte, request *ProtocolDataUnit) (*ProtocolDataUnit, error) {
+		if atomic.AddInt32(requests, 1) <= failures {
+			return nil, &ModbusError{FunctionCode: request.FunctionCode, ExceptionCode: exceptionCode}
+		}
+		if request.FunctionCode == FuncCodeReadHoldingRegisters {
+			return &ProtocolDataUnit{FunctionCode: request.FunctionCode, Data: []byte{2, 0, 7}}, nil
+		}
+		return request, nil
+	})
+	s := NewTCPServer("", handler)
+	return startTCPServer(t, s), requests, func() { s.Close() }
+}
+
+func TestRetryClient(t *testing.T) {
+	address, requests, stop := startFailingTCPServer(t, ExceptionCodeServerDeviceBusy, 2)
+	defer stop()
+
+	h := NewTCPClientHandler(address)
+	defer h.Close()
+	client := NewRetryClient(h, &RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond})
+	results, err := client.ReadHoldingRegisters(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0, 7}, results) {
+		t.Fatalf("unexpected results: % x", results)
+	}
+	if n := atomic.LoadInt32(requests); n != 3 {
+		t.Fatalf("unexpected number of requests: %v", n)
+	}
+}
+
+func TestRetryClientMaxAttempts(t *testing.T) {
+	address, requests, stop := startFailingTCPServer(t, ExceptionCodeGatewayTargetDeviceFailedToRespond, 5)
+	defer stop()
+
+	h := NewTCPClientHandler(address)
+	defer h.Close()
+	client := NewRetryClient(h, &RetryPolicy{MaxAttempts: 3})
+	_, err := client.ReadHoldingRegisters(1, 1)
+	if mbError, ok := err.(*ModbusError); !ok || mbError.ExceptionCode != ExceptionCodeGatewayTargetDeviceFailedToRespond {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := atomic.LoadInt32(requests); n != 3 {
+		t.Fatalf("unexpected number of requests: %v", n)
+	}
+}
+
+func TestRetryClientFatal(t *testing.T) {
+	address, requests, stop := startFailingTCPServer(t, ExceptionCodeIllegalDataAddress, 1)
+	defer stop()
+
+	h := NewTCPClientHandler(address)
+	defer h.Close()
+	client := NewRetryClient(h, &RetryPolicy{MaxAttempts: 3})
+	_, err := client.ReadHoldingRegisters(1, 1)
+	if mbError, ok := err.(*ModbusError); !ok || mbError.ExceptionCode != ExceptionCodeIllegalDataAddress {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Fatalf("unexpected number of requests: %v", n)
+	}
+}
+
+func TestRetryClientWrites(t *testing.T) {
+	address, requests, stop := startFailingTCPServer(t, ExceptionCodeServerDeviceBusy, 1)
+	defer stop()
+
+	h := NewTCPClientHandler(address)
+	defer h.Close()
+	policy := &RetryPolicy{MaxAttempts: 3}
+	client := NewRetryClient(h, policy)
+	_, err := client.WriteSingleRegister(1, 2)
+	if mbError, ok := err.(*ModbusError); !ok || mbError.ExceptionCode != ExceptionCodeServerDeviceBusy {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Fatalf("unexpected number of requests: %v", n)
+	}
+	atomic.StoreInt32(requests, 0)
+	policy.RetryWrites = true
+	if _, err = client.WriteSingleRegister(1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(requests); n != 2 {
+		t.Fatalf("unexpected number of requests: %v", n)
+	}
+}
+
+func TestRetryClientContext(t *testing.T) {
+	address, requests, stop := startFailingTCPServer(t, ExceptionCodeServerDeviceBusy, 5)
+	defer stop()
+
+	h := NewTCPClientHandler(address)
+	defer h.Close()
+	client := NewRetryClient(h, &RetryPolicy{MaxAttempts: 5, Backoff: time.Hour})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := client.ReadHoldingRegistersContext(ctx, 1, 1)
+	if mbError, ok := err.(*ModbusError); !ok || mbError.ExceptionCode != ExceptionCodeServerDeviceBusy {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Fatalf("unexpected number of requests: %v", n)
+	}
+}
+
+func TestRetryClientPartialResponse(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn, partial bool) {
+				defer conn.Close()
+				var request [12]byte
+				for {
+					if _, err := io.ReadFull(conn, request[:]); err != nil {
+						return
+					}
+					response := append(request[:4:4], 0, 5, request[6], request[7], 2, 0, 7)
+					if partial {
+						// Header only, the rest never comes
+						conn.Write(response[:7])
+						continue
+					}
+					conn.Write(response)
+				}
+			}(conn, i == 0)
+		}
+	}()
+
+	h := NewTCPClientHandler(ln.Addr().String())
+	h.Timeout = 100 * time.Millisecond
+	defer h.Close()
+	client := NewRetryClient(h, &RetryPolicy{MaxAttempts: 2})
+	results, err := client.ReadHoldingRegisters(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0, 7}, results) {
+		t.Fatalf("unexpected results: % x", results)
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{&ModbusError{ExceptionCode: ExceptionCodeAcknowledge}, true},
+		{&ModbusError{ExceptionCode: ExceptionCodeServerDeviceBusy}, true},
+		{&ModbusError{ExceptionCode: ExceptionCodeGatewayPathUnavailable}, true},
+		{&ModbusError{ExceptionCode: ExceptionCodeGatewayTargetDeviceFailedToRespond}, true},
+		{&ModbusError{ExceptionCode: ExceptionCodeIllegalFunction}, false},
+		{&ModbusError{ExceptionCode: ExceptionCodeIllegalDataAddress}, false},
+		{&ModbusError{ExceptionCode: ExceptionCodeIllegalDataValue}, false},
+		{&ModbusError{ExceptionCode: ExceptionCodeServerDeviceFailure}, false},
+		{&checksumError{name: "crc", checksum: 1, expected: 2}, true},
+		{serial.ErrTimeout, true},
+		{timeoutError{}, true},
+		{fmt.Errorf("read: %w", timeoutError{}), true},
+		{io.EOF, false},
+		{fmt.Errorf("modbus: response data is empty"), false},
+	}
+	for _, test := range tests {
+		if retryable := IsRetryable(test.err); retryable != test.retryable {
+			t.Errorf("%v: unexpected retryable %v", test.err, retryable)
+		}
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := &RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	expected := []time.Duration{10, 20, 40, 50, 50}
+	for i, e := range expected {
+		if delay := policy.backoff(i + 1); delay != e*time.Millisecond {
+			t.Errorf("attempt %v: unexpected delay %v", i+1, delay)
+		}
+	}
+	policy.MaxBackoff = 0
+	if delay := policy.backoff(100); delay <= 0 {
+		t.Errorf("unexpected delay %v", delay)
+	}
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if delay := policy.backoff(2); delay < 10*time.Millisecond || delay > 20*time.Millisecond {
+			t.Fatalf("unexpected delay %v", delay)
+		}
+	}
+}
+
+func TestRetryPolicyChecksum(t *testing.T) {
+	var crc crc
+	crc.reset().pushBytes([]byte{1, 3, 2, 0, 7})
+	checksum := crc.value()
+	_, err := (&rtuPackager{}).Decode([]byte{1, 3, 2, 0, 7, byte(checksum), byte(checksum>>8) + 1})
+	if !IsRetryable(err) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = (&asciiPackager{}).Decode([]byte(":010302000700\r\n"))
+	if !IsRetryable(err) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestRetryClientPipelineTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// The first request is not responded
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var request [12]byte
+		for i := 0; ; i++ {
+			if _, err := io.ReadFull(conn, request[:]); err != nil {
+				return
+			}
+			if i > 0 {
+				conn.Write(append(request[:4:4], 0, 5, request[6], request[7], 2, 0, 7))
+			}
+		}
+	}()
+
+	h := NewTCPPipelineClientHandler(ln.Addr().String())
+	h.Timeout = 50 * time.Millisecond
+	defer h.Close()
+	_, err = NewClient(h).ReadHoldingRegisters(1, 1)
+	if !IsRetryable(err) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	results, err := NewRetryClient(h, &RetryPolicy{MaxAttempts: 2}).ReadHoldingRegisters(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0, 7}, results) {
+		t.Fatalf("unexpected results: % x", results)
+	}
+}
@@ -5,10 +5,13 @@
 package modbus
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"time"
+
+	"github.com/goburrow/serial"
 )
 
 const (
@@ -16,6 +19,9 @@ const (
 	rtuMaxSize = 256
 
 	rtuExceptionSize = 5
+
+	// Read timeout of the serial port, which is polled in background
+	rtuPollInterval = 100 * time.Millisecond
 )
 
 // RTUClientHandler implements Packager and Transporter interface.
@@ -30,6 +36,7 @@ func NewRTUClientHandler(address string) *RTUClientHandler {
 	handler.Address = address
 	handler.Timeout = serialTimeout
 	handler.IdleTimeout = serialIdleTimeout
+	handler.TurnaroundDelay = serialTurnaroundDelay
 	return handler
 }
 
@@ -50,6 +57,16 @@ type rtuPackager struct {
 //  Data            : 0 up to 252 bytes
 //  CRC             : 2 byte
 func (mb *rtuPackager) Encode(pdu *ProtocolDataUnit) (adu []byte, err error) {
+	return mb.encodeSlave(mb.SlaveId, pdu)
+}
+
+// isBroadcast returns true if the request is sent to slave id 0.
+func (mb *rtuPackager) isBroadcast(aduRequest []byte) bool {
+	return len(aduRequest) > 0 && aduRequest[0] == 0
+}
+
+// encodeSlave is like Encode but sends the request to slaveId.
+func (mb *rtuPackager) encodeSlave(slaveId byte, pdu *ProtocolDataUnit) (adu []byte, err error) {
 	length := len(pdu.Data) + 4
 	if length > rtuMaxSize {
 		err = fmt.Errorf("modbus: length of data '%v' must not be bigger than '%v'", length, rtuMaxSize)
@@ -57,7 +74,7 @@ func (mb *rtuPackager) Encode(pdu *ProtocolDataUnit) (adu []byte, err error) {
 	}
 	adu = make([]byte, length)
 
-	adu[0] = mb.SlaveId
+	adu[0] = slaveId
 	adu[1] = pdu.FunctionCode
 	copy(adu[2:], pdu.Data)
 
@@ -95,7 +112,7 @@ func (mb *rtuPackager) Decode(adu []byte) (pdu *ProtocolDataUnit, err error) {
 	crc.reset().pushBytes(adu[0 : length-2])
 	checksum := uint16(adu[length-1])<<8 | uint16(adu[length-2])
 	if checksum != crc.value() {
-		err = fmt.Errorf("modbus: response crc '%v' does not match expected '%v'", checksum, crc.value())
+		err = &checksumError{name: "crc", checksum: checksum, expected: crc.value()}
 		return
 	}
 	// Function code & data
@@ -108,76 +125,315 @@ func (mb *rtuPackager) Decode(adu []byte) (pdu *ProtocolDataUnit, err error) {
 // rtuSerialTransporter implements Transporter interface.
 type rtuSerialTransporter struct {
 	serialPort
+	// Optional function returning the expected length of the response
+	// frame, including slave id and CRC, or 0 if it is not known.
+	// It is needed for function codes which are not in the specification.
+	ResponseLength func(aduRequest []byte) int
+	// Delay after a broadcast request, which is not responded, before
+	// sending the next request
+	TurnaroundDelay time.Duration
+	// Inter-character timeout (t1.5) and silent interval between frames
+	// (t3.5), which are calculated from BaudRate if they are zero.
+	// A response ends when the line is silent for FrameDelay, so USB
+	// serial adapters, which deliver received bytes in bursts, may need
+	// a longer one. CharacterDelay is not used for receiving, gaps shorter
+	// than FrameDelay are accepted within a response. It is only used to
+	// time the silence after requests which are not responded.
+	CharacterDelay time.Duration
+	FrameDelay     time.Duration
+
+	// Frames read from port in background
+	frames     *rtuFrameReader
+	framesPort io.ReadWriteCloser
+}
+
+// Connect opens the serial port and starts reading frames.
+func (mb *rtuSerialTransporter) Connect() (err error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	return mb.connect()
+}
+
+// connect opens the serial port if it is not connected and starts reading
+// frames from it. Caller must hold the mutex.
+func (mb *rtuSerialTransporter) connect() error {
+	if mb.port == nil {
+		config := mb.Config
+		// Port is only polled in background, Timeout applies to responses
+		config.Timeout = rtuPollInterval
+		port, err := openLocked(config)
+		if err != nil {
+			return err
+		}
+		mb.port = port
+	}
+	if mb.framesPort != mb.port {
+		mb.stopFrames()
+		_, frameDelay := mb.delays()
+		mb.frames = newRTUFrameReader(mb.port, frameDelay)
+		mb.framesPort = mb.port
+	}
+	return nil
+}
+
+// Close stops reading frames and closes the serial port.
+func (mb *rtuSerialTransporter) Close() (err error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	mb.stopFrames()
+	return mb.close()
+}
+
+// stopFrames stops reading frames of the previous port. Caller must hold
+// the mutex.
+func (mb *rtuSerialTransporter) stopFrames() {
+	if mb.frames != nil {
+		mb.frames.stop()
+		mb.frames = nil
+		mb.framesPort = nil
+	}
 }
 
 func (mb *rtuSerialTransporter) Send(aduRequest []byte) (aduResponse []byte, err error) {
-	// Make sure port is connected
-	if err = mb.serialPort.connect(); err != nil {
+	return mb.SendContext(context.Background(), aduRequest)
+}
+
+// SendContext is like Send but returns when ctx is done.
+func (mb *rtuSerialTransporter) SendContext(ctx context.Context, aduRequest []byte) (aduResponse []byte, err error) {
+	return mb.serialPort.sendContext(ctx, aduRequest, mb.send)
+}
+
+// sendOneWay writes the request without reading the response.
+func (mb *rtuSerialTransporter) sendOneWay(ctx context.Context, aduRequest []byte) (err error) {
+	_, err = mb.serialPort.sendContext(ctx, aduRequest, func(aduRequest []byte) (aduResponse []byte, err error) {
+		if err = mb.write(aduRequest); err != nil {
+			return
+		}
+		// Keep the line silent before the next frame
+		delay := mb.calculateDelay(len(aduRequest))
+		if aduRequest[0] == 0 {
+			delay += mb.TurnaroundDelay
+		}
+		time.Sleep(delay)
 		return
-	}
-	// Start the timer to close when idle
-	mb.serialPort.lastActivity = time.Now()
-	mb.serialPort.startCloseTimer()
+	})
+	return
+}
 
-	// Send the request
-	mb.serialPort.logf("modbus: sending % x\n", aduRequest)
-	if _, err = mb.port.Write(aduRequest); err != nil {
+// send writes the request and reads the response. Caller must hold the mutex.
+func (mb *rtuSerialTransporter) send(aduRequest []byte) (aduResponse []byte, err error) {
+	if err = mb.write(aduRequest); err != nil {
 		return
 	}
-	function := aduRequest[1]
-	functionFail := aduRequest[1] & 0x80
-	bytesToRead := calculateResponseLength(aduRequest)
-	time.Sleep(mb.calculateDelay(len(aduRequest) + bytesToRead))
-
-	var n int
-	var n1 int
-	var data [rtuMaxSize]byte
-	//We first read the minimum length and then read either the full package
-	//or the error package, depending on the error status (byte 2 of the response)
-	n, err = io.ReadAtLeast(mb.port, data[:], rtuMinSize)
+	bytesToRead := rtuResponseLength(mb.ResponseLength, aduRequest)
+	if aduResponse, err = mb.readFrame(aduRequest, bytesToRead); err != nil {
+		return
+	}
+	mb.serialPort.logf("modbus: received % x\n", aduResponse)
+	return
+}
+
+// readFrame reads the response of aduRequest, which ends when the line is
+// silent for FrameDelay. If the response is shorter than the length
+// predicted from the request and the bytes received, reading continues
+// until Timeout. Caller must hold the mutex.
+func (mb *rtuSerialTransporter) readFrame(aduRequest []byte, bytesToRead int) (aduResponse []byte, err error) {
+	var deadline time.Time
+	if mb.Timeout > 0 {
+		deadline = time.Now().Add(mb.Timeout)
+	}
+	_, frameDelay := mb.delays()
+	data, err := mb.frames.readChunk(mb.Timeout)
 	if err != nil {
 		return
 	}
-	//if the function is correct
-	if data[1] == function {
-		//we read the rest of the bytes
-		if n < bytesToRead {
-			if bytesToRead > rtuMinSize && bytesToRead <= rtuMaxSize {
-				if bytesToRead > n {
-					n1, err = io.ReadFull(mb.port, data[n:bytesToRead])
-					n += n1
+	for len(data) < rtuMaxSize {
+		var chunk []byte
+		chunk, err = mb.frames.readChunk(frameDelay)
+		if err == serial.ErrTimeout {
+			length, known := rtuFrameLength(aduRequest, data, bytesToRead)
+			if !known || len(data) >= length {
+				err = nil
+				break
+			}
+			// The rest is delayed, e.g. by the serial adapter
+			var timeout time.Duration
+			if !deadline.IsZero() {
+				if timeout = time.Until(deadline); timeout <= 0 {
+					return
 				}
 			}
+			chunk, err = mb.frames.readChunk(timeout)
 		}
-	} else if data[1] == functionFail {
-		//for error we need to read 5 bytes
-		if n < rtuExceptionSize {
-			n1, err = io.ReadFull(mb.port, data[n:rtuExceptionSize])
+		if err != nil {
+			return
 		}
-		n += n1
+		data = append(data, chunk...)
 	}
+	aduResponse = data
+	return
+}
 
+// write connects and writes the request. Caller must hold the mutex.
+func (mb *rtuSerialTransporter) write(aduRequest []byte) (err error) {
+	// Make sure port is connected
+	if err = mb.connect(); err != nil {
+		return
+	}
+	// Late responses of previous requests
+	if n := mb.frames.discard(); n > 0 {
+		mb.serialPort.logf("modbus: discarded %v bytes\n", n)
+	}
+	// Start the timer to close when idle
+	mb.serialPort.lastActivity = time.Now()
+	mb.serialPort.startCloseTimer()
+
+	// Send the request
+	mb.serialPort.logf("modbus: sending % x\n", aduRequest)
+	_, err = mb.port.Write(aduRequest)
+	return
+}
+
+// readRTUResponse reads the response of aduRequest, which is expected to
+// have bytesToRead bytes, into data, which must be at least rtuMaxSize bytes.
+func readRTUResponse(r io.Reader, aduRequest []byte, data []byte, bytesToRead int) (aduResponse []byte, err error) {
+	// Read the minimum length first and then the rest, whose length may
+	// depend on the exception status and byte counts in the response
+	n, err := io.ReadAtLeast(r, data[:rtuMaxSize], rtuMinSize)
 	if err != nil {
 		return
 	}
+	for {
+		length, known := rtuFrameLength(aduRequest, data[:n], bytesToRead)
+		if !known || n >= length {
+			break
+		}
+		if length > rtuMaxSize {
+			err = fmt.Errorf("modbus: response length '%v' must not be bigger than '%v'", length, rtuMaxSize)
+			return
+		}
+		var n1 int
+		n1, err = io.ReadFull(r, data[n:length])
+		n += n1
+		if err != nil {
+			return
+		}
+	}
 	aduResponse = data[:n]
-	mb.serialPort.logf("modbus: received % x\n", aduResponse)
 	return
 }
 
+// rtuFrameLength returns the length of the response to aduRequest predicted
+// from data received so far, or the length needed to predict it, and
+// whether the length is known. bytesToRead is the length calculated from
+// the request.
+func rtuFrameLength(aduRequest []byte, data []byte, bytesToRead int) (length int, known bool) {
+	// Slave id and function code
+	if len(data) < 2 {
+		return 2, true
+	}
+	function := aduRequest[1]
+	switch data[1] {
+	case function:
+	case function | 0x80:
+		return rtuExceptionSize, true
+	default:
+		return 0, false
+	}
+	switch function {
+	case FuncCodeGetCommEventLog, FuncCodeReportServerId:
+		// Byte count
+		if len(data) < 3 {
+			return 3, true
+		}
+		return rtuMinSize + 1 + int(data[2]), true
+	case FuncCodeReadFIFOQueue:
+		// Byte count (2 bytes)
+		if len(data) < 4 {
+			return 4, true
+		}
+		return rtuMinSize + 2 + int(binary.BigEndian.Uint16(data[2:])), true
+	case FuncCodeEncapsulatedInterfaceTransport:
+		if len(aduRequest) > 2 && aduRequest[2] == MEITypeReadDeviceIdentification {
+			return deviceIdentificationFrameLength(data), true
+		}
+	}
+	if bytesToRead > rtuMinSize {
+		return bytesToRead, true
+	}
+	return 0, false
+}
+
+// deviceIdentificationFrameLength returns the length of a Read Device
+// Identification response, which is only known after reading each object
+// header, or the length needed to read the next header.
+func deviceIdentificationFrameLength(data []byte) int {
+	// Slave id, function code, MEI type, read device id code, conformity
+	// level, more follows, next object id and number of objects
+	length := 8
+	if len(data) < length {
+		return length
+	}
+	count := int(data[7])
+	for i := 0; i < count; i++ {
+		// Object id and length
+		if len(data) < length+2 {
+			return length + 2
+		}
+		length += 2 + int(data[length+1])
+	}
+	// CRC
+	return length + 2
+}
+
 // calculateDelay roughly calculates time needed for the next frame.
-// See MODBUS over Serial Line - Specification and Implementation Guide (page 13).
 func (mb *rtuSerialTransporter) calculateDelay(chars int) time.Duration {
-	var characterDelay, frameDelay int // us
+	characterDelay, frameDelay := mb.delays()
+	return characterDelay*time.Duration(chars) + frameDelay
+}
 
-	if mb.BaudRate <= 0 || mb.BaudRate > 19200 {
-		characterDelay = 750
-		frameDelay = 1750
+// delays returns CharacterDelay and FrameDelay, or those calculated from
+// BaudRate if they are not set.
+func (mb *rtuSerialTransporter) delays() (characterDelay, frameDelay time.Duration) {
+	characterDelay, frameDelay = rtuDelays(mb.BaudRate)
+	if mb.CharacterDelay > 0 {
+		characterDelay = mb.CharacterDelay
+	}
+	if mb.FrameDelay > 0 {
+		frameDelay = mb.FrameDelay
+	}
+	return
+}
+
+// rtuDelays returns the inter-character timeout (t1.5) and the silent
+// interval between frames (t3.5) for the baud rate.
+// See MODBUS over Serial Line - Specification and Implementation Guide (page 13).
+func rtuDelays(baudRate int) (characterDelay, frameDelay time.Duration) {
+	var characterDelayUs, frameDelayUs int
+
+	if baudRate <= 0 || baudRate > 19200 {
+		characterDelayUs = 750
+		frameDelayUs = 1750
 	} else {
-		characterDelay = 15000000 / mb.BaudRate
-		frameDelay = 35000000 / mb.BaudRate
+		characterDelayUs = 15000000 / baudRate
+		frameDelayUs = 35000000 / baudRate
+	}
+	characterDelay = time.Duration(characterDelayUs) * time.Microsecond
+	frameDelay = time.Duration(frameDelayUs) * time.Microsecond
+	return
+}
+
+// rtuResponseLength returns the response length given by responseLength if
+// it is known, otherwise calculated from the request.
+func rtuResponseLength(responseLength func(aduRequest []byte) int, aduRequest []byte) int {
+	if responseLength != nil {
+		if length := responseLength(aduRequest); length > 0 {
+			return length
+		}
 	}
-	return time.Duration(characterDelay*chars+frameDelay) * time.Microsecond
+	return calculateResponseLength(aduRequest)
 }
 
 func calculateResponseLength(adu []byte) int {
@@ -202,6 +458,33 @@ func calculateResponseLength(adu []byte) int {
 		length += 4
 	case FuncCodeMaskWriteRegister:
 		length += 6
+	case FuncCodeReadFileRecord:
+		// Response data length, file response length and reference type
+		// of each sub-request and their records
+		length++
+		for i := 3; i+7 <= len(adu)-2; i += 7 {
+			length += 2 + 2*int(binary.BigEndian.Uint16(adu[i+5:]))
+		}
+	case FuncCodeWriteFileRecord:
+		length = len(adu)
+	case FuncCodeReadExceptionStatus:
+		length++
+	case FuncCodeDiagnostics:
+		// Sub-function and data are echoed or replaced by a value
+		length = len(adu)
+	case FuncCodeGetCommEventCounter:
+		length += 4
+	case FuncCodeGetCommEventLog:
+		// Minimum, the byte count in the response tells the actual length
+		length += 1 + 6
+	case FuncCodeReportServerId:
+		// Minimum, assuming one-byte server id
+		length += 1 + 2
+	case FuncCodeEncapsulatedInterfaceTransport:
+		// Minimum, the number and lengths of objects tell the actual length
+		if adu[2] == MEITypeReadDeviceIdentification {
+			length += 6
+		}
 	case FuncCodeReadFIFOQueue:
 		// undetermined
 	default:
@@ -6,7 +6,11 @@ package modbus
 
 import (
 	"bytes"
+	"context"
+	"net"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 func TestRTUEncoding(t *testing.T) {
@@ -56,6 +60,13 @@ var responseLengthTests = []struct {
 	{[]byte{0x11, 6, 0, 1, 0, 3, 0x9A, 0x9B}, 8},
 	{[]byte{0x11, 0xF, 0, 0x13, 0, 0xA, 2, 0xCD, 1, 0xBF, 0xB}, 8},
 	{[]byte{0x11, 0x10, 0, 1, 0, 2, 4, 0, 0xA, 1, 2, 0xC6, 0xF0}, 8},
+	{[]byte{0x11, 7, 0x4C, 0x22}, 5},
+	{[]byte{0x11, 8, 0, 0, 0xA5, 0x37, 0xDA, 0x8D}, 8},
+	{[]byte{0x11, 0xB, 0x4C, 0x27}, 8},
+	{[]byte{0x11, 0xC, 0x0D, 0xE6}, 11},
+	{[]byte{0x11, 0x11, 0xCC, 0x2C}, 7},
+	{[]byte{1, 0x14, 0xE, 6, 0, 4, 0, 1, 0, 2, 6, 0, 3, 0, 9, 0, 1, 0x33, 0x77}, 15},
+	{[]byte{1, 0x15, 9, 6, 0, 4, 0, 7, 0, 1, 0x06, 0xAF, 0x60, 0xA3}, 14},
 }
 
 func TestCalculateResponseLength(t *testing.T) {
@@ -68,6 +79,188 @@ func TestCalculateResponseLength(t *testing.T) {
 	}
 }
 
+func TestReadRTUResponseCommEventLog(t *testing.T) {
+	aduRequest := []byte{0x11, 0xC, 0x0D, 0xE6}
+	// Response with 2 events, which is read byte by byte
+	aduResponse := []byte{0x11, 0xC, 8, 0, 0, 1, 8, 1, 0x21, 0x20, 0, 0xA3, 0x59}
+	var data [rtuMaxSize]byte
+	actual, err := readRTUResponse(iotest.OneByteReader(bytes.NewReader(aduResponse)), aduRequest, data[:], calculateResponseLength(aduRequest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(aduResponse, actual) {
+		t.Fatalf("unexpected response: % x", actual)
+	}
+}
+
+func TestRTUFrameLength(t *testing.T) {
+	tests := []struct {
+		aduRequest []byte
+		data       []byte
+		length     int
+		known      bool
+	}{
+		// Registers
+		{[]byte{1, 3, 0, 0, 0, 2, 0xC4, 0xB}, []byte{1}, 2, true},
+		{[]byte{1, 3, 0, 0, 0, 2, 0xC4, 0xB}, []byte{1, 3, 4}, 9, true},
+		{[]byte{1, 3, 0, 0, 0, 2, 0xC4, 0xB}, []byte{1, 0x83, 2}, 5, true},
+		// Response of other function code
+		{[]byte{1, 3, 0, 0, 0, 2, 0xC4, 0xB}, []byte{1, 4, 4}, 0, false},
+		// FIFO
+		{[]byte{1, 0x18, 0x04, 0xDE, 0x07, 0x3F}, []byte{1, 0x18, 0}, 4, true},
+		{[]byte{1, 0x18, 0x04, 0xDE, 0x07, 0x3F}, []byte{1, 0x18, 0, 6, 0, 2}, 12, true},
+		// Comm event log
+		{[]byte{0x11, 0xC, 0x0D, 0xE6}, []byte{0x11, 0xC, 8}, 13, true},
+		// Device identification with 1 object
+		{[]byte{1, 0x2B, 0x0E, 1, 0, 0x70, 0x77}, []byte{1, 0x2B, 0x0E, 1, 1, 0, 0, 1}, 10, true},
+		{[]byte{1, 0x2B, 0x0E, 1, 0, 0x70, 0x77}, []byte{1, 0x2B, 0x0E, 1, 1, 0, 0, 1, 0, 3}, 15, true},
+		// Vendor function code
+		{[]byte{1, 0x41, 0xAA, 0xD0, 0x30}, []byte{1, 0x41, 1, 2}, 0, false},
+	}
+	for _, test := range tests {
+		length, known := rtuFrameLength(test.aduRequest, test.data, calculateResponseLength(test.aduRequest))
+		if length != test.length || known != test.known {
+			t.Errorf("% x: unexpected length %v (%v)", test.data, length, known)
+		}
+	}
+}
+
+// startRTUTestSlave responds to requests on port with the frame returned by
+// respond, which is written in parts separated by gap.
+func startRTUTestSlave(port net.Conn, gap time.Duration, respond func(aduRequest []byte) [][]byte) {
+	go func() {
+		defer port.Close()
+		var b [rtuMaxSize]byte
+		for {
+			n, err := port.Read(b[:])
+			if err != nil {
+				return
+			}
+			for i, p := range respond(b[:n]) {
+				if i > 0 {
+					time.Sleep(gap)
+				}
+				if _, err = port.Write(p); err != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+func TestRTUClientFrameDelay(t *testing.T) {
+	serverPort, clientPort := net.Pipe()
+	defer clientPort.Close()
+	startRTUTestSlave(serverPort, 10*time.Millisecond, func(aduRequest []byte) [][]byte {
+		aduResponse, _ := (&rtuPackager{SlaveId: 1}).Encode(&ProtocolDataUnit{
+			FunctionCode: aduRequest[1],
+			Data:         []byte{1, 2, 3, 4, 5, 6},
+		})
+		return [][]byte{aduResponse[:3], aduResponse[3:7], aduResponse[7:]}
+	})
+
+	h := NewRTUClientHandler("")
+	h.SlaveId = 1
+	h.FrameDelay = 100 * time.Millisecond
+	h.port = clientPort
+	// Length of the response is not known
+	response, err := NewClient(h).Send(&ProtocolDataUnit{FunctionCode: 0x42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.FunctionCode != 0x42 || !bytes.Equal([]byte{1, 2, 3, 4, 5, 6}, response.Data) {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+}
+
+func TestRTUClientCharacterDelay(t *testing.T) {
+	serverPort, clientPort := net.Pipe()
+	defer clientPort.Close()
+	startRTUTestSlave(serverPort, 20*time.Millisecond, func(aduRequest []byte) [][]byte {
+		aduResponse, _ := (&rtuPackager{SlaveId: 1}).Encode(&ProtocolDataUnit{
+			FunctionCode: aduRequest[1],
+			Data:         []byte{2, 0, 7},
+		})
+		return [][]byte{aduResponse[:2], aduResponse[2:4], aduResponse[4:]}
+	})
+
+	h := NewRTUClientHandler("")
+	h.SlaveId = 1
+	h.CharacterDelay = time.Millisecond
+	h.FrameDelay = 100 * time.Millisecond
+	h.port = clientPort
+	// Gaps longer than CharacterDelay do not end the response
+	results, err := NewClient(h).ReadHoldingRegisters(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0, 7}, results) {
+		t.Fatalf("unexpected results: %v", results)
+	}
+}
+
+func TestRTUClientReadFIFOQueue(t *testing.T) {
+	serverPort, clientPort := net.Pipe()
+	defer clientPort.Close()
+	late := false
+	startRTUTestSlave(serverPort, 10*time.Millisecond, func(aduRequest []byte) [][]byte {
+		aduResponse, _ := (&rtuPackager{SlaveId: 1}).Encode(&ProtocolDataUnit{
+			FunctionCode: FuncCodeReadFIFOQueue,
+			Data:         []byte{0, 6, 0, 2, 1, 2, 3, 4},
+		})
+		parts := [][]byte{aduResponse[:3], aduResponse[3:]}
+		if !late {
+			// Late response which must not be taken as the next one
+			parts = append(parts, aduResponse)
+			late = true
+		}
+		return parts
+	})
+
+	h := NewRTUClientHandler("")
+	h.SlaveId = 1
+	h.port = clientPort
+	client := NewClient(h)
+	for i := 0; i < 2; i++ {
+		results, err := client.ReadFIFOQueue(4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal([]byte{1, 2, 3, 4}, results) {
+			t.Fatalf("unexpected results: % x", results)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestRTUSerialTransporterContext(t *testing.T) {
+	serverPort, clientPort := net.Pipe()
+	h := NewRTUClientHandler("")
+	h.SlaveId = 1
+	h.port = clientPort
+	client := NewClient(h)
+
+	// Slave receives the request but does not respond
+	go func() {
+		var b [rtuMaxSize]byte
+		serverPort.Read(b[:])
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := client.ReadHoldingRegistersContext(ctx, 0, 1)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Pending read is finished by the error and the port is closed
+	serverPort.Close()
+	if err = h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if h.port != nil {
+		t.Fatal("port is not closed")
+	}
+}
+
 func BenchmarkRTUEncoder(b *testing.B) {
 	encoder := rtuPackager{
 		SlaveId: 10,
@@ -96,3 +289,45 @@ func BenchmarkRTUDecoder(b *testing.B) {
 		}
 	}
 }
+
+func TestRTUClientSend(t *testing.T) {
+	serverPort, clientPort := net.Pipe()
+	defer clientPort.Close()
+	go func() {
+		defer serverPort.Close()
+		var b [rtuMaxSize]byte
+		for {
+			if _, err := serverPort.Read(b[:]); err != nil {
+				return
+			}
+			// Response of vendor function code is written in two parts
+			aduResponse, _ := (&rtuPackager{SlaveId: 1}).Encode(&ProtocolDataUnit{
+				FunctionCode: 0x41,
+				Data:         []byte{1, 2, 3, 4, 5, 6},
+			})
+			for _, p := range [][]byte{aduResponse[:5], aduResponse[5:]} {
+				if _, err := serverPort.Write(p); err != nil {
+					return
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	}()
+
+	h := NewRTUClientHandler("")
+	h.SlaveId = 1
+	h.port = clientPort
+	h.ResponseLength = func(aduRequest []byte) int {
+		if aduRequest[1] == 0x41 {
+			return 10
+		}
+		return 0
+	}
+	response, err := NewClient(h).Send(&ProtocolDataUnit{FunctionCode: 0x41, Data: []byte{0xAA}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.FunctionCode != 0x41 || !bytes.Equal([]byte{1, 2, 3, 4, 5, 6}, response.Data) {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+}
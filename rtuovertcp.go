@@ -0,0 +1,89 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"time"
+)
+
+// RTUOverTCPClientHandler implements Packager and Transporter interface.
+// It sends RTU frames over a TCP connection, which is usually provided by
+// serial device servers.
+type RTUOverTCPClientHandler struct {
+	rtuPackager
+	rtuTCPTransporter
+}
+
+// NewRTUOverTCPClientHandler allocates a new RTUOverTCPClientHandler.
+func NewRTUOverTCPClientHandler(address string) *RTUOverTCPClientHandler {
+	h := &RTUOverTCPClientHandler{}
+	h.Address = address
+	h.Timeout = tcpTimeout
+	h.IdleTimeout = tcpIdleTimeout
+	h.TurnaroundDelay = serialTurnaroundDelay
+	return h
+}
+
+// RTUOverTCPClient creates RTU over TCP client with default handler and given connect string.
+func RTUOverTCPClient(address string) Client {
+	handler := NewRTUOverTCPClientHandler(address)
+	return NewClient(handler)
+}
+
+// rtuTCPTransporter implements Transporter interface.
+type rtuTCPTransporter struct {
+	tcpTransporter
+	// Optional function returning the expected length of the response
+	// frame, including slave id and CRC, or 0 if it is not known.
+	// It is needed for function codes which are not in the specification.
+	ResponseLength func(aduRequest []byte) int
+	// Delay after a broadcast request, which is not responded, before
+	// sending the next request
+	TurnaroundDelay time.Duration
+}
+
+// Send sends data to server and reads the response of length expected from
+// the request.
+func (mb *rtuTCPTransporter) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	return mb.SendContext(context.Background(), aduRequest)
+}
+
+// SendContext is like Send but aborts the I/O when ctx is done.
+func (mb *rtuTCPTransporter) SendContext(ctx context.Context, aduRequest []byte) (aduResponse []byte, err error) {
+	return mb.tcpTransporter.sendContext(ctx, aduRequest, mb.exchange)
+}
+
+// sendOneWay writes the request without reading the response.
+func (mb *rtuTCPTransporter) sendOneWay(ctx context.Context, aduRequest []byte) (err error) {
+	_, err = mb.tcpTransporter.sendContext(ctx, aduRequest, func(aduRequest []byte) (aduResponse []byte, err error) {
+		mb.logf("modbus: sending % x", aduRequest)
+		if _, err = mb.conn.Write(aduRequest); err != nil {
+			return
+		}
+		// Devices behind the gateway need time to process the broadcast
+		if aduRequest[0] == 0 {
+			time.Sleep(mb.TurnaroundDelay)
+		}
+		return
+	})
+	return
+}
+
+// exchange writes the request and reads the response. Caller must hold the mutex.
+func (mb *rtuTCPTransporter) exchange(aduRequest []byte) (aduResponse []byte, err error) {
+	mb.logf("modbus: sending % x", aduRequest)
+	if _, err = mb.conn.Write(aduRequest); err != nil {
+		return
+	}
+	var data [rtuMaxSize]byte
+	if aduResponse, err = readRTUResponse(mb.conn, aduRequest, data[:], rtuResponseLength(mb.ResponseLength, aduRequest)); err != nil {
+		// Without transaction id, a late response would be taken as
+		// the response of the next request so the caller drops the connection
+		return
+	}
+	mb.logf("modbus: received % x\n", aduResponse)
+	return
+}
@@ -0,0 +1,120 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// startConnServer listens on a local TCP port and serves each connection
+// with serve.
+func startConnServer(t *testing.T, serve func(conn io.ReadWriteCloser) error) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer ln.Close()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestRTUOverTCPClientHandler(t *testing.T) {
+	m := NewDataModel(10, 10, 10, 10)
+	m.SetHoldingRegisters(1, []uint16{0x1234, 0x5678})
+	s := NewRTUServer("", 17, m)
+	defer s.Close()
+
+	h := NewRTUOverTCPClientHandler(startConnServer(t, s.Serve))
+	h.SlaveId = 17
+	h.Timeout = time.Second
+	defer h.Close()
+	client := NewClient(h)
+
+	results, err := client.ReadHoldingRegisters(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0x12, 0x34, 0x56, 0x78}, results) {
+		t.Fatalf("unexpected results: %v", results)
+	}
+	_, err = client.ReadHoldingRegisters(9, 2)
+	if mbError, ok := err.(*ModbusError); !ok || mbError.ExceptionCode != ExceptionCodeIllegalDataAddress {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	results, err = client.WriteSingleCoil(2, 0xFF00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0xFF, 0x00}, results) {
+		t.Fatalf("unexpected results: %v", results)
+	}
+}
+
+func TestRTUOverTCPClientHandlerTimeout(t *testing.T) {
+	// Server responds too late
+	address := startConnServer(t, func(conn io.ReadWriteCloser) error {
+		defer conn.Close()
+		var b [rtuMaxSize]byte
+		for {
+			if _, err := conn.Read(b[:]); err != nil {
+				return err
+			}
+			time.Sleep(100 * time.Millisecond)
+			conn.Write([]byte{0x11, 0x06, 0x00, 0x01, 0x00, 0x03, 0x9A, 0x9B})
+		}
+	})
+	h := NewRTUOverTCPClientHandler(address)
+	h.SlaveId = 17
+	h.Timeout = 50 * time.Millisecond
+	defer h.Close()
+
+	if _, err := NewClient(h).WriteSingleRegister(1, 3); err == nil {
+		t.Fatal("timeout error expected")
+	}
+	if h.conn != nil {
+		t.Fatal("connection is not closed")
+	}
+}
+
+func TestRTUOverTCPClientHandlerBroadcast(t *testing.T) {
+	requests := make(chan byte, 10)
+	handler := ServerHandlerFunc(func(slaveId byte, request *ProtocolDataUnit) (*ProtocolDataUnit, error) {
+		requests <- slaveId
+		return request, nil
+	})
+	s := NewRTUServer("", 17, handler)
+	defer s.Close()
+
+	h := NewRTUOverTCPClientHandler(startConnServer(t, s.Serve))
+	h.Timeout = time.Second
+	h.TurnaroundDelay = 50 * time.Millisecond
+	defer h.Close()
+	start := time.Now()
+	results, err := NewClient(h).WriteSingleRegister(1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < h.TurnaroundDelay {
+		t.Fatalf("unexpected turnaround delay: %v", elapsed)
+	}
+	if !bytes.Equal([]byte{0, 3}, results) {
+		t.Fatalf("unexpected results: %v", results)
+	}
+	if slaveId := <-requests; slaveId != 0 {
+		t.Fatalf("unexpected slave id: %v", slaveId)
+	}
+}
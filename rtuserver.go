@@ -0,0 +1,182 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"io"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+// RTUServer serves modbus requests on a serial line in RTU mode.
+type RTUServer struct {
+	serialServer
+}
+
+// NewRTUServer allocates and initializes a RTUServer.
+func NewRTUServer(address string, slaveId byte, handler ServerHandler) *RTUServer {
+	s := &RTUServer{}
+	s.Address = address
+	s.Timeout = serialTimeout
+	s.SlaveId = slaveId
+	s.Handler = handler
+	return s
+}
+
+// ListenAndServe opens the serial port in Address and then calls Serve.
+func (s *RTUServer) ListenAndServe() error {
+	port, err := s.open()
+	if err != nil {
+		return err
+	}
+	return s.Serve(port)
+}
+
+// Serve reads requests from the port and writes back responses.
+// Frames are delimited by the silent interval calculated from BaudRate.
+// It always returns a non-nil error, which is ErrServerClosed after Close
+// is called.
+func (s *RTUServer) Serve(port io.ReadWriteCloser) error {
+	if !s.start(port) {
+		port.Close()
+		return ErrServerClosed
+	}
+	_, frameDelay := rtuDelays(s.BaudRate)
+	frames := newRTUFrameReader(port, frameDelay)
+	defer frames.stop()
+
+	for {
+		aduRequest, err := frames.readFrame()
+		if err != nil {
+			return s.serveError(err)
+		}
+		s.logf("modbus: received % x\n", aduRequest)
+		aduResponse := s.handle(aduRequest)
+		if aduResponse == nil {
+			continue
+		}
+		s.logf("modbus: sending % x\n", aduResponse)
+		if _, err = port.Write(aduResponse); err != nil {
+			return s.serveError(err)
+		}
+	}
+}
+
+// handle processes the request frame and returns the response frame or
+// nil if there is no response.
+func (s *RTUServer) handle(aduRequest []byte) (aduResponse []byte) {
+	length := len(aduRequest)
+	if length < rtuMinSize || length > rtuMaxSize {
+		s.logf("modbus: request length '%v' must be between '%v' and '%v'", length, rtuMinSize, rtuMaxSize)
+		s.discard(length > rtuMaxSize)
+		return
+	}
+	return s.serialServer.handle(&rtuPackager{SlaveId: s.SlaveId}, aduRequest[0], aduRequest)
+}
+
+// rtuFrameReader delimits RTU frames by the silent interval between them.
+type rtuFrameReader struct {
+	frameDelay time.Duration
+
+	chunks chan []byte
+	done   chan struct{}
+	// err is set before chunks is closed
+	err error
+}
+
+// newRTUFrameReader starts reading r in background.
+func newRTUFrameReader(r io.Reader, frameDelay time.Duration) *rtuFrameReader {
+	fr := &rtuFrameReader{
+		frameDelay: frameDelay,
+		chunks:     make(chan []byte, 16),
+		done:       make(chan struct{}),
+	}
+	go fr.run(r)
+	return fr
+}
+
+func (fr *rtuFrameReader) run(r io.Reader) {
+	defer close(fr.chunks)
+	for {
+		data := make([]byte, rtuMaxSize)
+		n, err := r.Read(data)
+		if n > 0 {
+			select {
+			case fr.chunks <- data[:n]:
+			case <-fr.done:
+				return
+			}
+		}
+		if err != nil {
+			// Timeout only means the line is silent
+			if err == serial.ErrTimeout {
+				continue
+			}
+			fr.err = err
+			return
+		}
+	}
+}
+
+// readFrame waits for the first bytes of a frame and returns all bytes
+// received until the line is silent for frameDelay.
+func (fr *rtuFrameReader) readFrame() (frame []byte, err error) {
+	if frame, err = fr.readChunk(0); err != nil {
+		return
+	}
+	for {
+		chunk, err := fr.readChunk(fr.frameDelay)
+		if err != nil {
+			// Line is silent, other errors will be returned in the next call
+			return frame, nil
+		}
+		frame = append(frame, chunk...)
+	}
+}
+
+// readChunk returns the bytes received next or serial.ErrTimeout if nothing
+// is received within timeout. It waits without timeout if timeout is zero.
+func (fr *rtuFrameReader) readChunk(timeout time.Duration) (chunk []byte, err error) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case c, ok := <-fr.chunks:
+		if !ok {
+			if err = fr.err; err == nil {
+				err = io.ErrClosedPipe
+			}
+			return
+		}
+		chunk = c
+	case <-expired:
+		err = serial.ErrTimeout
+	}
+	return
+}
+
+// discard drops the bytes which have been received and returns their number.
+func (fr *rtuFrameReader) discard() (n int) {
+	for {
+		select {
+		case chunk, ok := <-fr.chunks:
+			if !ok {
+				return
+			}
+			n += len(chunk)
+		default:
+			return
+		}
+	}
+}
+
+// stop stops reading in background.
+func (fr *rtuFrameReader) stop() {
+	close(fr.done)
+}
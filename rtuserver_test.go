@@ -0,0 +1,404 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRTUServer(t *testing.T) {
+	requests := make(chan byte, 10)
+	handler := ServerHandlerFunc(func(slaveId byte, request *ProtocolDataUnit) (*ProtocolDataUnit, error) {
+		requests <- slaveId
+		return &ProtocolDataUnit{
+			FunctionCode: request.FunctionCode,
+			Data:         request.Data[:4],
+		}, nil
+	})
+	serverPort, clientPort := net.Pipe()
+	defer clientPort.Close()
+	s := NewRTUServer("", 17, handler)
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(serverPort)
+	}()
+
+	h := NewRTUClientHandler("")
+	h.SlaveId = 17
+	h.port = clientPort
+	client := NewClient(h)
+	results, err := client.WriteSingleRegister(1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0, 3}, results) {
+		t.Fatalf("unexpected results: %v", results)
+	}
+	if slaveId := <-requests; slaveId != 17 {
+		t.Fatalf("unexpected slave id: %v", slaveId)
+	}
+
+	frames := [][]byte{
+		// Other slave
+		{0x12, 0x06, 0x00, 0x01, 0x00, 0x03, 0x9A, 0xA8},
+		// Invalid CRC
+		{0x11, 0x06, 0x00, 0x01, 0x00, 0x03, 0x9A, 0x9A},
+		// Broadcast
+		{0x00, 0x06, 0x00, 0x01, 0x00, 0x03, 0x99, 0xDA},
+	}
+	for _, frame := range frames {
+		if _, err = clientPort.Write(frame); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if slaveId := <-requests; slaveId != 0 {
+		t.Fatalf("unexpected slave id: %v", slaveId)
+	}
+	clientPort.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	var b [1]byte
+	if n, err := clientPort.Read(b[:]); n != 0 || err == nil {
+		t.Fatalf("unexpected response: %v, %v", b[:n], err)
+	}
+	if len(requests) != 0 {
+		t.Fatalf("unexpected requests: %v", len(requests))
+	}
+
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-served; err != ErrServerClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestRTUFrameReader(t *testing.T) {
+	r, w := net.Pipe()
+	defer w.Close()
+	frames := newRTUFrameReader(r, 20*time.Millisecond)
+	defer frames.stop()
+
+	go func() {
+		w.Write([]byte{1, 2, 3})
+		time.Sleep(5 * time.Millisecond)
+		w.Write([]byte{4, 5})
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte{6, 7, 8, 9})
+	}()
+	frame, err := frames.readFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{1, 2, 3, 4, 5}, frame) {
+		t.Fatalf("unexpected frame: %v", frame)
+	}
+	frame, err = frames.readFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{6, 7, 8, 9}, frame) {
+		t.Fatalf("unexpected frame: %v", frame)
+	}
+}
+
+func TestRTUServerDiagnostics(t *testing.T) {
+	m := NewDataModel(10, 10, 10, 10)
+	m.SetExceptionStatus(0x6D)
+	s := NewRTUServer("", 1, m)
+	// handle encodes the request to slaveId and returns the decoded response
+	handle := func(slaveId byte, functionCode byte, data ...uint16) *ProtocolDataUnit {
+		packager := rtuPackager{SlaveId: slaveId}
+		aduRequest, err := packager.Encode(&ProtocolDataUnit{FunctionCode: functionCode, Data: dataBlock(data...)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		aduResponse := s.handle(aduRequest)
+		if aduResponse == nil {
+			return nil
+		}
+		response, err := packager.Decode(aduResponse)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+	expectCount := func(subFunction uint16, count uint16) {
+		response := handle(1, FuncCodeDiagnostics, subFunction, 0)
+		if response == nil || !bytes.Equal(dataBlock(subFunction, count), response.Data) {
+			t.Fatalf("sub-function %v: unexpected response %v, expected count %v", subFunction, response, count)
+		}
+	}
+
+	if response := handle(1, FuncCodeReadExceptionStatus); response == nil || !bytes.Equal([]byte{0x6D}, response.Data) {
+		t.Fatalf("unexpected response: %v", response)
+	}
+	// Exception response
+	if response := handle(1, FuncCodeReadHoldingRegisters, 10, 1); response == nil || response.FunctionCode != 0x83 {
+		t.Fatalf("unexpected response: %v", response)
+	}
+	// Other slave and broadcast
+	handle(2, FuncCodeReadHoldingRegisters, 0, 1)
+	handle(0, FuncCodeWriteSingleRegister, 0, 1)
+	// Invalid CRC and length
+	s.handle([]byte{1, 3, 0, 0, 0, 1, 0, 0})
+	s.handle([]byte{1, 3})
+	s.handle(make([]byte, rtuMaxSize+1))
+
+	expectCount(SubFuncCodeReturnBusMessageCount, 5)
+	expectCount(SubFuncCodeReturnBusCommunicationErrorCount, 2)
+	expectCount(SubFuncCodeReturnBusExceptionErrorCount, 1)
+	expectCount(SubFuncCodeReturnServerMessageCount, 7)
+	expectCount(SubFuncCodeReturnServerNoResponseCount, 1)
+	expectCount(SubFuncCodeReturnBusCharacterOverrunCount, 1)
+	if response := handle(1, FuncCodeDiagnostics, SubFuncCodeClearOverrunCounter, 0); response == nil {
+		t.Fatal("response expected")
+	}
+	expectCount(SubFuncCodeReturnBusCharacterOverrunCount, 0)
+	if response := handle(1, FuncCodeDiagnostics, SubFuncCodeClearCounters, 0); response == nil {
+		t.Fatal("response expected")
+	}
+	expectCount(SubFuncCodeReturnServerMessageCount, 1)
+	if response := handle(1, FuncCodeDiagnostics, 0x99, 0); response == nil || response.FunctionCode != 0x88 {
+		t.Fatalf("unexpected response: %v", response)
+	}
+
+	// Only Restart Communications is processed in Listen Only Mode
+	if response := handle(1, FuncCodeDiagnostics, SubFuncCodeForceListenOnlyMode, 0); response != nil {
+		t.Fatalf("unexpected response: %v", response)
+	}
+	if response := handle(1, FuncCodeReadHoldingRegisters, 0, 1); response != nil {
+		t.Fatalf("unexpected response: %v", response)
+	}
+	if response := handle(1, FuncCodeDiagnostics, SubFuncCodeReturnQueryData, 0x1234); response != nil {
+		t.Fatalf("unexpected response: %v", response)
+	}
+	if response := handle(1, FuncCodeDiagnostics, SubFuncCodeRestartCommunications, 0); response != nil {
+		t.Fatalf("unexpected response: %v", response)
+	}
+	if response := handle(1, FuncCodeDiagnostics, SubFuncCodeReturnQueryData, 0x1234); response == nil || !bytes.Equal([]byte{0, 0, 0x12, 0x34}, response.Data) {
+		t.Fatalf("unexpected response: %v", response)
+	}
+}
+
+func TestRTUClientDiagnostics(t *testing.T) {
+	serverPort, clientPort := net.Pipe()
+	defer clientPort.Close()
+	s := NewRTUServer("", 17, NewDataModel(10, 10, 10, 10))
+	defer s.Close()
+	go s.Serve(serverPort)
+
+	h := NewRTUClientHandler("")
+	h.SlaveId = 17
+	h.port = clientPort
+	client := NewClient(h)
+
+	results, err := client.ReturnQueryData([]byte{0xA5, 0x37})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0xA5, 0x37}, results) {
+		t.Fatalf("unexpected results: %v", results)
+	}
+	count, err := client.ReturnServerMessageCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("unexpected count: %v", count)
+	}
+	if err = client.ClearCounters(); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.RestartCommunications(true); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := client.ReturnDiagnosticRegister(); err != nil || value != 0 {
+		t.Fatalf("unexpected diagnostic register: %v, %v", value, err)
+	}
+	// No response is waited for
+	if err = client.ForceListenOnlyMode(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Diagnostics(SubFuncCodeForceListenOnlyMode, []byte{0, 0}); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
+func TestRTUServerCommEvents(t *testing.T) {
+	s := NewRTUServer("", 1, NewDataModel(10, 10, 10, 10))
+	handle := func(slaveId byte, functionCode byte, data ...uint16) *ProtocolDataUnit {
+		packager := rtuPackager{SlaveId: slaveId}
+		aduRequest, err := packager.Encode(&ProtocolDataUnit{FunctionCode: functionCode, Data: dataBlock(data...)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		aduResponse := s.handle(aduRequest)
+		if aduResponse == nil {
+			return nil
+		}
+		response, err := packager.Decode(aduResponse)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	handle(1, FuncCodeReadHoldingRegisters, 0, 1)
+	handle(1, FuncCodeReadHoldingRegisters, 10, 1)
+	handle(0, FuncCodeWriteSingleRegister, 0, 1)
+	s.handle([]byte{1, 3, 0, 0, 0, 1, 0, 0})
+	if response := handle(1, FuncCodeGetCommEventCounter); response == nil || !bytes.Equal([]byte{0, 0, 0, 2}, response.Data) {
+		t.Fatalf("unexpected response: %v", response)
+	}
+	expected := []byte{15, 0, 0, 0, 2, 0, 5,
+		0x80, 0x40, 0x80, 0x82, 0xC0, 0x41, 0x80, 0x40, 0x80}
+	if response := handle(1, FuncCodeGetCommEventLog); response == nil || !bytes.Equal(expected, response.Data) {
+		t.Fatalf("unexpected response: %v, expected data %v", response, expected)
+	}
+
+	// Events in Listen Only Mode are cleared by Restart Communications
+	handle(1, FuncCodeDiagnostics, SubFuncCodeForceListenOnlyMode, 0)
+	if response := handle(1, FuncCodeGetCommEventLog); response != nil {
+		t.Fatalf("unexpected response: %v", response)
+	}
+	if s.events[0] != 0xA0 || s.events[1] != CommEventEnteredListenOnlyMode {
+		t.Fatalf("unexpected events: %v", s.events)
+	}
+	handle(1, FuncCodeDiagnostics, SubFuncCodeRestartCommunications, 0xFF00)
+	expected = []byte{8, 0, 0, 0, 0, 0, 1, 0x80, CommEventRestart}
+	if response := handle(1, FuncCodeGetCommEventLog); response == nil || !bytes.Equal(expected, response.Data) {
+		t.Fatalf("unexpected response: %v, expected data %v", response, expected)
+	}
+
+	// Only latest events are kept
+	for i := 0; i < commEventLogSize; i++ {
+		handle(1, FuncCodeReadCoils, 0, 1)
+	}
+	if response := handle(1, FuncCodeGetCommEventLog); response == nil || len(response.Data) != 71 || response.Data[0] != 70 {
+		t.Fatalf("unexpected response: %v", response)
+	}
+}
+
+func TestRTUClientCommEvents(t *testing.T) {
+	serverPort, clientPort := net.Pipe()
+	defer clientPort.Close()
+	s := NewRTUServer("", 17, NewDataModel(10, 10, 10, 10))
+	defer s.Close()
+	go s.Serve(serverPort)
+
+	h := NewRTUClientHandler("")
+	h.SlaveId = 17
+	h.port = clientPort
+	client := NewClient(h)
+
+	if _, err := client.ReadCoils(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	counter, err := client.GetCommEventCounter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counter.Status != 0 || counter.EventCount != 1 {
+		t.Fatalf("unexpected counter: %+v", counter)
+	}
+	log, err := client.GetCommEventLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.EventCount != 1 || log.MessageCount != 3 || !bytes.Equal([]byte{0x80, 0x40, 0x80, 0x40, 0x80}, log.Events) {
+		t.Fatalf("unexpected log: %+v", log)
+	}
+}
+
+func TestRTUClientUnit(t *testing.T) {
+	handler := ServerHandlerFunc(func(slaveId byte, request *ProtocolDataUnit) (*ProtocolDataUnit, error) {
+		return &ProtocolDataUnit{
+			FunctionCode: request.FunctionCode,
+			Data:         []byte{2, 0, slaveId},
+		}, nil
+	})
+	serverPort, clientPort := net.Pipe()
+	defer clientPort.Close()
+	s := NewRTUServer("", 17, handler)
+	defer s.Close()
+	go s.Serve(serverPort)
+
+	h := NewRTUClientHandler("")
+	h.SlaveId = 1
+	h.Timeout = 100 * time.Millisecond
+	h.port = clientPort
+	client := NewClient(h)
+	results, err := client.Unit(17).ReadHoldingRegisters(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0, 17}, results) {
+		t.Fatalf("unexpected results: %v", results)
+	}
+}
+
+func TestRTUClientBroadcast(t *testing.T) {
+	requests := make(chan *ProtocolDataUnit, 10)
+	handler := ServerHandlerFunc(func(slaveId byte, request *ProtocolDataUnit) (*ProtocolDataUnit, error) {
+		if slaveId != 0 {
+			t.Errorf("unexpected slave id: %v", slaveId)
+		}
+		requests <- request
+		return request, nil
+	})
+	serverPort, clientPort := net.Pipe()
+	defer clientPort.Close()
+	s := NewRTUServer("", 17, handler)
+	defer s.Close()
+	go s.Serve(serverPort)
+
+	h := NewRTUClientHandler("")
+	h.Timeout = time.Second
+	h.TurnaroundDelay = 50 * time.Millisecond
+	h.port = clientPort
+	client := NewClient(h)
+	start := time.Now()
+	results, err := client.WriteMultipleRegisters(1, 2, []byte{0, 3, 0, 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < h.TurnaroundDelay {
+		t.Fatalf("unexpected turnaround delay: %v", elapsed)
+	}
+	if !bytes.Equal([]byte{0, 2}, results) {
+		t.Fatalf("unexpected results: %v", results)
+	}
+	request := <-requests
+	if request.FunctionCode != FuncCodeWriteMultipleRegisters || !bytes.Equal([]byte{0, 1, 0, 2, 4, 0, 3, 0, 4}, request.Data) {
+		t.Fatalf("unexpected request: %+v", request)
+	}
+
+	h.SlaveId = 17
+	if err = client.Unit(0).WriteSingleCoilBool(5, true); err != nil {
+		t.Fatal(err)
+	}
+	request = <-requests
+	if request.FunctionCode != FuncCodeWriteSingleCoil || !bytes.Equal([]byte{0, 5, 0xFF, 0}, request.Data) {
+		t.Fatalf("unexpected request: %+v", request)
+	}
+	response, err := client.Unit(0).Send(&ProtocolDataUnit{FunctionCode: FuncCodeWriteSingleRegister, Data: []byte{0, 1, 0, 2}})
+	if err != nil || response != nil {
+		t.Fatalf("unexpected response: %v, %v", response, err)
+	}
+	<-requests
+
+	// Reads are not sent
+	_, err = client.Unit(0).ReadHoldingRegisters(0, 1)
+	if err == nil || err.Error() != "modbus: function code '3' must not be broadcast" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if len(requests) != 0 {
+		t.Fatalf("unexpected requests: %v", len(requests))
+	}
+}
@@ -5,6 +5,7 @@
 package modbus
 
 import (
+	"context"
 	"io"
 	"log"
 	"sync"
@@ -17,6 +18,8 @@ const (
 	// Default timeout
 	serialTimeout     = 5 * time.Second
 	serialIdleTimeout = 60 * time.Second
+	// Delay after broadcast requests for devices to process them
+	serialTurnaroundDelay = 100 * time.Millisecond
 )
 
 // serialPort has configuration and I/O controller.
@@ -27,7 +30,7 @@ type serialPort struct {
 	Logger      *log.Logger
 	IdleTimeout time.Duration
 
-	mu sync.Mutex
+	mu portLock
 	// port is platform-dependent data structure for serial port.
 	port         io.ReadWriteCloser
 	lastActivity time.Time
@@ -53,6 +56,20 @@ func (mb *serialPort) connect() error {
 	return nil
 }
 
+// openLocked opens the serial port in config, which can be closed while it
+// is read in another goroutine.
+func openLocked(config serial.Config) (io.ReadWriteCloser, error) {
+	// Reading must time out periodically so that the port can be closed.
+	if config.Timeout <= 0 {
+		config.Timeout = serialTimeout
+	}
+	port, err := serial.Open(&config)
+	if err != nil {
+		return nil, err
+	}
+	return &lockedPort{port: port}, nil
+}
+
 func (mb *serialPort) Close() (err error) {
 	mb.mu.Lock()
 	defer mb.mu.Unlock()
@@ -69,6 +86,82 @@ func (mb *serialPort) close() (err error) {
 	return
 }
 
+// sendContext calls send with the mutex held and returns when it finishes
+// or ctx is done. Serial I/O can not be interrupted, so an aborted send
+// keeps the port locked until it completes within Timeout and the port is
+// then closed to discard the late response. Requests waiting for the port
+// return as soon as their ctx is done.
+func (mb *serialPort) sendContext(ctx context.Context, aduRequest []byte,
+	send func(aduRequest []byte) (aduResponse []byte, err error)) (aduResponse []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	if ctx.Done() == nil {
+		mb.mu.Lock()
+		defer mb.mu.Unlock()
+		return send(aduRequest)
+	}
+	if err = mb.mu.lockContext(ctx); err != nil {
+		return
+	}
+	type result struct {
+		aduResponse []byte
+		err         error
+	}
+	done := make(chan result, 1)
+	aborted := make(chan struct{})
+	go func() {
+		defer mb.mu.Unlock()
+		aduResponse, err := send(aduRequest)
+		select {
+		case <-aborted:
+			mb.logf("modbus: closing connection due to %v", ctx.Err())
+			mb.close()
+		default:
+		}
+		done <- result{aduResponse, err}
+	}()
+	select {
+	case r := <-done:
+		return r.aduResponse, r.err
+	case <-ctx.Done():
+		close(aborted)
+		return nil, ctx.Err()
+	}
+}
+
+// portLock is a mutex which can also be acquired until a context is done.
+type portLock struct {
+	once sync.Once
+	sem  chan struct{}
+}
+
+func (l *portLock) semaphore() chan struct{} {
+	l.once.Do(func() {
+		l.sem = make(chan struct{}, 1)
+	})
+	return l.sem
+}
+
+func (l *portLock) Lock() {
+	l.semaphore() <- struct{}{}
+}
+
+func (l *portLock) Unlock() {
+	<-l.semaphore()
+}
+
+// lockContext acquires the lock or returns the error of ctx if it is done
+// first.
+func (l *portLock) lockContext(ctx context.Context) error {
+	select {
+	case l.semaphore() <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (mb *serialPort) logf(format string, v ...interface{}) {
 	if mb.Logger != nil {
 		mb.Logger.Printf(format, v...)
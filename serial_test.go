@@ -2,6 +2,7 @@ package modbus
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"testing"
 	"time"
@@ -34,3 +35,41 @@ func TestSerialCloseIdle(t *testing.T) {
 		t.Fatalf("serial port is not closed when inactivity: %+v", port)
 	}
 }
+
+func TestSerialSendContextQueued(t *testing.T) {
+	var s serialPort
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	send := func(aduRequest []byte) ([]byte, error) {
+		close(started)
+		<-release
+		return aduRequest, nil
+	}
+	// The first request is aborted but keeps the port until it is released
+	ctx, cancel := context.WithCancel(context.Background())
+	aborted := make(chan error, 1)
+	go func() {
+		_, err := s.sendContext(ctx, []byte{1}, send)
+		aborted <- err
+	}()
+	<-started
+	cancel()
+	if err := <-aborted; err != context.Canceled {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := s.sendContext(ctx, []byte{2}, func(aduRequest []byte) ([]byte, error) {
+		t.Error("request must not be sent")
+		return aduRequest, nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("unexpected elapsed time: %v", elapsed)
+	}
+}
@@ -0,0 +1,324 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"github.com/goburrow/serial"
+)
+
+// serialServer has common configuration and port handling of the servers
+// on a serial line.
+type serialServer struct {
+	serialPort
+	// Address of the server on the serial line
+	SlaveId byte
+	// Handler to process requests
+	Handler ServerHandler
+
+	closed bool
+	// Diagnostics, which are only accessed by the serving goroutine
+	counters           serialCounters
+	diagnosticRegister uint16
+	listenOnly         bool
+	// End of ASCII frames replacing LF, zero if not changed
+	asciiDelimiter byte
+	// Communication event counter and log (latest first)
+	eventCount uint16
+	events     []byte
+}
+
+const (
+	// Maximum number of events in the communication event log
+	commEventLogSize = 64
+)
+
+// serialCounters are the diagnostic counters of a server on a serial line.
+type serialCounters struct {
+	busMessage            uint16
+	busCommunicationError uint16
+	busExceptionError     uint16
+	serverMessage         uint16
+	serverNoResponse      uint16
+	serverNAK             uint16
+	serverBusy            uint16
+	busCharacterOverrun   uint16
+}
+
+// open opens the serial port in the configuration.
+func (s *serialServer) open() (io.ReadWriteCloser, error) {
+	return openLocked(s.Config)
+}
+
+// start sets the port to be served, it returns false if the server is closed.
+func (s *serialServer) start(port io.ReadWriteCloser) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+	s.port = port
+	return true
+}
+
+// serveError returns ErrServerClosed instead of err if the server is closed.
+func (s *serialServer) serveError(err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrServerClosed
+	}
+	return err
+}
+
+// handle decodes the request frame sent to slaveId and returns the response
+// frame encoded by packager or nil if there is no response.
+func (s *serialServer) handle(packager Packager, slaveId byte, aduRequest []byte) (aduResponse []byte) {
+	request, err := packager.Decode(aduRequest)
+	if err != nil {
+		s.logf("modbus: %v", err)
+		s.discard(false)
+		return
+	}
+	s.counters.busMessage++
+	// Ignore requests to other slaves
+	if slaveId != s.SlaveId && slaveId != 0 {
+		return
+	}
+	s.counters.serverMessage++
+	if slaveId == 0 {
+		s.logEvent(s.receiveEvent(CommEventReceiveBroadcast))
+	} else {
+		s.logEvent(s.receiveEvent(0))
+	}
+	var response *ProtocolDataUnit
+	switch {
+	case request.FunctionCode == FuncCodeDiagnostics:
+		response = s.diagnostics(request)
+	case s.listenOnly:
+	case request.FunctionCode == FuncCodeGetCommEventCounter:
+		response = s.commEventCounter(request)
+	case request.FunctionCode == FuncCodeGetCommEventLog:
+		response = s.commEventLog(request)
+	default:
+		response = serve(s.Handler, slaveId, request)
+	}
+	// Event counter is not incremented for exceptions and fetching events
+	if response != nil && response.FunctionCode&0x80 == 0 &&
+		request.FunctionCode != FuncCodeGetCommEventCounter && request.FunctionCode != FuncCodeGetCommEventLog {
+		s.eventCount++
+	}
+	// No response to broadcast
+	if response == nil || slaveId == 0 {
+		s.counters.serverNoResponse++
+		return
+	}
+	event := byte(CommEventSend)
+	if response.FunctionCode&0x80 != 0 {
+		s.counters.busExceptionError++
+		if len(response.Data) > 0 {
+			switch response.Data[0] {
+			case ExceptionCodeIllegalFunction, ExceptionCodeIllegalDataAddress, ExceptionCodeIllegalDataValue:
+				event |= CommEventSendReadException
+			case ExceptionCodeServerDeviceFailure:
+				event |= CommEventSendAbortException
+			case ExceptionCodeAcknowledge:
+				event |= CommEventSendBusyException
+			case ExceptionCodeServerDeviceBusy:
+				event |= CommEventSendBusyException
+				s.counters.serverBusy++
+			case ExceptionCodeNegativeAcknowledge:
+				event |= CommEventSendNAKException
+				s.counters.serverNAK++
+			}
+		}
+	}
+	s.logEvent(event)
+	if aduResponse, err = packager.Encode(response); err != nil {
+		s.logf("modbus: %v", err)
+		aduResponse, _ = packager.Encode(exceptionResponse(request.FunctionCode, ExceptionCodeServerDeviceFailure))
+	}
+	return
+}
+
+// discard counts a frame which can not be decoded or is too long.
+func (s *serialServer) discard(overrun bool) {
+	if overrun {
+		s.counters.busCharacterOverrun++
+		s.logEvent(s.receiveEvent(CommEventReceiveOverrun))
+	} else {
+		s.counters.busCommunicationError++
+		s.logEvent(s.receiveEvent(CommEventReceiveCommError))
+	}
+}
+
+// receiveEvent returns the receive event with the flags.
+func (s *serialServer) receiveEvent(flags byte) byte {
+	event := CommEventReceive | flags
+	if s.listenOnly {
+		event |= CommEventReceiveListenOnlyMode
+	}
+	return event
+}
+
+// logEvent adds the event to the communication event log.
+func (s *serialServer) logEvent(event byte) {
+	if len(s.events) < commEventLogSize {
+		s.events = append(s.events, 0)
+	}
+	copy(s.events[1:], s.events)
+	s.events[0] = event
+}
+
+// commEventCounter returns the response of Get Comm Event Counter.
+func (s *serialServer) commEventCounter(request *ProtocolDataUnit) *ProtocolDataUnit {
+	if len(request.Data) != 0 {
+		return exceptionResponse(request.FunctionCode, ExceptionCodeIllegalDataValue)
+	}
+	// Status is always ready as requests are processed one by one
+	return &ProtocolDataUnit{
+		FunctionCode: request.FunctionCode,
+		Data:         dataBlock(0, s.eventCount),
+	}
+}
+
+// commEventLog returns the response of Get Comm Event Log.
+func (s *serialServer) commEventLog(request *ProtocolDataUnit) *ProtocolDataUnit {
+	if len(request.Data) != 0 {
+		return exceptionResponse(request.FunctionCode, ExceptionCodeIllegalDataValue)
+	}
+	data := dataBlock(0, s.eventCount, s.counters.busMessage)
+	data = append(data, s.events...)
+	return &ProtocolDataUnit{
+		FunctionCode: request.FunctionCode,
+		Data:         append([]byte{byte(len(data))}, data...),
+	}
+}
+
+// diagnostics processes Diagnostics requests and returns the response or nil
+// if there is no response. In Listen Only Mode, only Restart Communications
+// is processed.
+func (s *serialServer) diagnostics(request *ProtocolDataUnit) (response *ProtocolDataUnit) {
+	if len(request.Data) < 2 {
+		return exceptionResponse(request.FunctionCode, ExceptionCodeIllegalDataValue)
+	}
+	subFunction := binary.BigEndian.Uint16(request.Data)
+	data := request.Data[2:]
+	if s.listenOnly && subFunction != SubFuncCodeRestartCommunications {
+		return
+	}
+	// Except Return Query Data, requests have 2-byte data
+	if subFunction != SubFuncCodeReturnQueryData && len(data) != 2 {
+		return exceptionResponse(request.FunctionCode, ExceptionCodeIllegalDataValue)
+	}
+	var value uint16
+	switch subFunction {
+	case SubFuncCodeReturnQueryData:
+		return request
+	case SubFuncCodeRestartCommunications:
+		if value = binary.BigEndian.Uint16(data); value != 0 && value != 0xFF00 {
+			return exceptionResponse(request.FunctionCode, ExceptionCodeIllegalDataValue)
+		}
+		listenOnly := s.listenOnly
+		s.listenOnly = false
+		s.counters = serialCounters{}
+		s.eventCount = 0
+		if value == 0xFF00 {
+			s.events = s.events[:0]
+		}
+		s.logEvent(CommEventRestart)
+		// No response if the port was in Listen Only Mode
+		if listenOnly {
+			return
+		}
+		return request
+	case SubFuncCodeReturnDiagnosticRegister:
+		value = s.diagnosticRegister
+	case SubFuncCodeChangeASCIIInputDelimiter:
+		s.asciiDelimiter = data[0]
+		return request
+	case SubFuncCodeForceListenOnlyMode:
+		s.listenOnly = true
+		s.logEvent(CommEventEnteredListenOnlyMode)
+		return
+	case SubFuncCodeClearCounters:
+		s.counters = serialCounters{}
+		s.diagnosticRegister = 0
+		s.eventCount = 0
+		return request
+	case SubFuncCodeReturnBusMessageCount:
+		value = s.counters.busMessage
+	case SubFuncCodeReturnBusCommunicationErrorCount:
+		value = s.counters.busCommunicationError
+	case SubFuncCodeReturnBusExceptionErrorCount:
+		value = s.counters.busExceptionError
+	case SubFuncCodeReturnServerMessageCount:
+		value = s.counters.serverMessage
+	case SubFuncCodeReturnServerNoResponseCount:
+		value = s.counters.serverNoResponse
+	case SubFuncCodeReturnServerNAKCount:
+		value = s.counters.serverNAK
+	case SubFuncCodeReturnServerBusyCount:
+		value = s.counters.serverBusy
+	case SubFuncCodeReturnBusCharacterOverrunCount:
+		value = s.counters.busCharacterOverrun
+	case SubFuncCodeClearOverrunCounter:
+		s.counters.busCharacterOverrun = 0
+		return request
+	default:
+		return exceptionResponse(request.FunctionCode, ExceptionCodeIllegalFunction)
+	}
+	return &ProtocolDataUnit{
+		FunctionCode: request.FunctionCode,
+		Data:         dataBlock(subFunction, value),
+	}
+}
+
+// Close closes the serial port being served.
+func (s *serialServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	return s.close()
+}
+
+// lockedPort prevents a serial port from being closed while it is read,
+// which is not supported by the serial package.
+type lockedPort struct {
+	mu     sync.Mutex
+	port   serial.Port
+	closed bool
+}
+
+func (p *lockedPort) Read(b []byte) (n int, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return 0, io.ErrClosedPipe
+	}
+	return p.port.Read(b)
+}
+
+func (p *lockedPort) Write(b []byte) (n int, err error) {
+	return p.port.Write(b)
+}
+
+func (p *lockedPort) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	return p.port.Close()
+}
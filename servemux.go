@@ -0,0 +1,288 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// Request is a decoded modbus request.
+// Fields which are not used by the function code are zero.
+type Request struct {
+	// Slave (unit) id the request is sent to, 0 for broadcast
+	SlaveId      byte
+	FunctionCode byte
+	// Starting address (read starting address for ReadWriteMultipleRegisters,
+	// FIFO pointer address for ReadFIFOQueue)
+	Address uint16
+	// Quantity of bits or registers (quantity to read for
+	// ReadWriteMultipleRegisters)
+	Quantity uint16
+	// Write starting address and quantity to write for
+	// ReadWriteMultipleRegisters
+	WriteAddress  uint16
+	WriteQuantity uint16
+	// Output value for WriteSingleCoil (0xFF00 or 0x0000) and register
+	// value for WriteSingleRegister
+	Value uint16
+	// Masks for MaskWriteRegister
+	AndMask uint16
+	OrMask  uint16
+	// Values to write: coil status for WriteMultipleCoils, registers value
+	// for WriteMultipleRegisters and ReadWriteMultipleRegisters
+	Values []byte
+	// Data of the request PDU
+	Data []byte
+}
+
+// Handler processes a decoded request.
+//
+// For read functions, HandleModbus returns the coil status or registers
+// value read and the response is completed with byte count (and FIFO count).
+// Responses of write functions echo the request so results are ignored.
+// For other function codes, results are the data of the response PDU.
+// If err is a *ModbusError, its exception code is sent back to the client.
+type Handler interface {
+	HandleModbus(request *Request) (results []byte, err error)
+}
+
+// HandlerFunc is an adapter to allow the use of ordinary functions as handlers.
+type HandlerFunc func(request *Request) (results []byte, err error)
+
+// HandleModbus calls f(request).
+func (f HandlerFunc) HandleModbus(request *Request) (results []byte, err error) {
+	return f(request)
+}
+
+// ServeMux routes requests to the handlers registered for their function
+// code and slave id. Requests of function codes which are not registered
+// are responded with ExceptionCodeIllegalFunction.
+type ServeMux struct {
+	mu       sync.RWMutex
+	handlers map[muxKey]Handler
+}
+
+// muxKey identifies handlers by function code and, unless anySlave is set,
+// slave id.
+type muxKey struct {
+	anySlave     bool
+	slaveId      byte
+	functionCode byte
+}
+
+// NewServeMux allocates a new ServeMux.
+func NewServeMux() *ServeMux {
+	return &ServeMux{handlers: make(map[muxKey]Handler)}
+}
+
+// Handle registers the handler for requests of the function code sent to
+// any slave id which does not have its own handler.
+func (mux *ServeMux) Handle(functionCode byte, handler Handler) {
+	mux.handle(muxKey{anySlave: true, functionCode: functionCode}, handler)
+}
+
+// HandleFunc registers the handler function for requests of the function
+// code sent to any slave id which does not have its own handler.
+func (mux *ServeMux) HandleFunc(functionCode byte, handler func(request *Request) (results []byte, err error)) {
+	mux.Handle(functionCode, HandlerFunc(handler))
+}
+
+// HandleSlave registers the handler for requests of the function code sent
+// to the slave id.
+func (mux *ServeMux) HandleSlave(slaveId, functionCode byte, handler Handler) {
+	mux.handle(muxKey{slaveId: slaveId, functionCode: functionCode}, handler)
+}
+
+func (mux *ServeMux) handle(key muxKey, handler Handler) {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	if handler == nil {
+		delete(mux.handlers, key)
+	} else {
+		mux.handlers[key] = handler
+	}
+}
+
+// Handler returns the handler for the function code and slave id, or nil
+// if there is none.
+func (mux *ServeMux) Handler(slaveId, functionCode byte) Handler {
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
+
+	if h, ok := mux.handlers[muxKey{slaveId: slaveId, functionCode: functionCode}]; ok {
+		return h
+	}
+	return mux.handlers[muxKey{anySlave: true, functionCode: functionCode}]
+}
+
+// ServeModbus dispatches the request to the registered handler.
+func (mux *ServeMux) ServeModbus(slaveId byte, request *ProtocolDataUnit) (response *ProtocolDataUnit, err error) {
+	h := mux.Handler(slaveId, request.FunctionCode)
+	if h == nil {
+		err = &ModbusError{FunctionCode: request.FunctionCode, ExceptionCode: ExceptionCodeIllegalFunction}
+		return
+	}
+	return serveHandler(h, slaveId, request)
+}
+
+// serveHandler decodes the request, passes it to handler and encodes
+// the response.
+func serveHandler(handler Handler, slaveId byte, request *ProtocolDataUnit) (response *ProtocolDataUnit, err error) {
+	req, err := decodeRequest(slaveId, request)
+	if err != nil {
+		return
+	}
+	results, err := handler.HandleModbus(req)
+	if err != nil {
+		return
+	}
+	return encodeResponse(req, results)
+}
+
+// decodeRequest decodes and validates the fields of request PDU.
+func decodeRequest(slaveId byte, pdu *ProtocolDataUnit) (request *Request, err error) {
+	request = &Request{
+		SlaveId:      slaveId,
+		FunctionCode: pdu.FunctionCode,
+		Data:         pdu.Data,
+	}
+	data := pdu.Data
+	valid := true
+	switch pdu.FunctionCode {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs:
+		valid = len(data) == 4
+		if valid {
+			request.Address = binary.BigEndian.Uint16(data)
+			request.Quantity = binary.BigEndian.Uint16(data[2:])
+			valid = request.Quantity >= 1 && request.Quantity <= 2000
+		}
+	case FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters:
+		valid = len(data) == 4
+		if valid {
+			request.Address = binary.BigEndian.Uint16(data)
+			request.Quantity = binary.BigEndian.Uint16(data[2:])
+			valid = request.Quantity >= 1 && request.Quantity <= 125
+		}
+	case FuncCodeWriteSingleCoil:
+		valid = len(data) == 4
+		if valid {
+			request.Address = binary.BigEndian.Uint16(data)
+			request.Value = binary.BigEndian.Uint16(data[2:])
+			valid = request.Value == 0xFF00 || request.Value == 0x0000
+		}
+	case FuncCodeWriteSingleRegister:
+		valid = len(data) == 4
+		if valid {
+			request.Address = binary.BigEndian.Uint16(data)
+			request.Value = binary.BigEndian.Uint16(data[2:])
+		}
+	case FuncCodeWriteMultipleCoils:
+		valid = len(data) >= 5
+		if valid {
+			request.Address = binary.BigEndian.Uint16(data)
+			request.Quantity = binary.BigEndian.Uint16(data[2:])
+			request.Values = data[5:]
+			count := int(data[4])
+			valid = request.Quantity >= 1 && request.Quantity <= 1968 &&
+				count == (int(request.Quantity)+7)/8 && count == len(request.Values)
+		}
+	case FuncCodeWriteMultipleRegisters:
+		valid = len(data) >= 5
+		if valid {
+			request.Address = binary.BigEndian.Uint16(data)
+			request.Quantity = binary.BigEndian.Uint16(data[2:])
+			request.Values = data[5:]
+			count := int(data[4])
+			valid = request.Quantity >= 1 && request.Quantity <= 123 &&
+				count == 2*int(request.Quantity) && count == len(request.Values)
+		}
+	case FuncCodeMaskWriteRegister:
+		valid = len(data) == 6
+		if valid {
+			request.Address = binary.BigEndian.Uint16(data)
+			request.AndMask = binary.BigEndian.Uint16(data[2:])
+			request.OrMask = binary.BigEndian.Uint16(data[4:])
+		}
+	case FuncCodeReadWriteMultipleRegisters:
+		valid = len(data) >= 9
+		if valid {
+			request.Address = binary.BigEndian.Uint16(data)
+			request.Quantity = binary.BigEndian.Uint16(data[2:])
+			request.WriteAddress = binary.BigEndian.Uint16(data[4:])
+			request.WriteQuantity = binary.BigEndian.Uint16(data[6:])
+			request.Values = data[9:]
+			count := int(data[8])
+			valid = request.Quantity >= 1 && request.Quantity <= 125 &&
+				request.WriteQuantity >= 1 && request.WriteQuantity <= 121 &&
+				count == 2*int(request.WriteQuantity) && count == len(request.Values)
+		}
+	case FuncCodeReadFIFOQueue:
+		valid = len(data) == 2
+		if valid {
+			request.Address = binary.BigEndian.Uint16(data)
+		}
+	case FuncCodeReadFileRecord:
+		valid = len(data) >= 1
+		if valid {
+			count := int(data[0])
+			valid = count >= 7 && count <= fileReadMaxDataLength && count%7 == 0 && count == len(data)-1
+		}
+	case FuncCodeWriteFileRecord:
+		valid = len(data) >= 1
+		if valid {
+			count := int(data[0])
+			valid = count >= 9 && count <= fileWriteMaxDataLength && count == len(data)-1
+		}
+	case FuncCodeReadExceptionStatus, FuncCodeReportServerId:
+		valid = len(data) == 0
+	case FuncCodeDiagnostics:
+		valid = len(data) >= 2
+	case FuncCodeEncapsulatedInterfaceTransport:
+		// MEI type
+		valid = len(data) >= 1
+	}
+	if !valid {
+		err = &ModbusError{FunctionCode: pdu.FunctionCode, ExceptionCode: ExceptionCodeIllegalDataValue}
+	}
+	return
+}
+
+// encodeResponse creates the response PDU from results of the request.
+func encodeResponse(request *Request, results []byte) (response *ProtocolDataUnit, err error) {
+	response = &ProtocolDataUnit{FunctionCode: request.FunctionCode}
+	length := -1
+	switch request.FunctionCode {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs:
+		length = (int(request.Quantity) + 7) / 8
+		response.Data = dataBlockSuffix(results)
+	case FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters, FuncCodeReadWriteMultipleRegisters:
+		length = 2 * int(request.Quantity)
+		response.Data = dataBlockSuffix(results)
+	case FuncCodeWriteSingleCoil, FuncCodeWriteSingleRegister, FuncCodeMaskWriteRegister:
+		response.Data = request.Data
+	case FuncCodeWriteMultipleCoils, FuncCodeWriteMultipleRegisters:
+		response.Data = request.Data[:4]
+	case FuncCodeReadFIFOQueue:
+		if len(results)%2 != 0 || len(results) > 62 {
+			err = fmt.Errorf("modbus: fifo value size '%v' is invalid", len(results))
+			response = nil
+			return
+		}
+		response.Data = make([]byte, 4+len(results))
+		binary.BigEndian.PutUint16(response.Data, uint16(2+len(results)))
+		binary.BigEndian.PutUint16(response.Data[2:], uint16(len(results)/2))
+		copy(response.Data[4:], results)
+	default:
+		response.Data = results
+	}
+	if length >= 0 && len(results) != length {
+		err = fmt.Errorf("modbus: results size '%v' does not match expected '%v'", len(results), length)
+		response = nil
+	}
+	return
+}
@@ -0,0 +1,73 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestServeMux(t *testing.T) {
+	mux := NewServeMux()
+	mux.HandleFunc(FuncCodeReadInputRegisters, func(request *Request) ([]byte, error) {
+		if request.Address != 2 || request.Quantity != 2 {
+			t.Errorf("unexpected request: %+v", request)
+		}
+		return []byte{0, 3, 0, 4}, nil
+	})
+	mux.HandleSlave(5, FuncCodeReadInputRegisters, HandlerFunc(func(request *Request) ([]byte, error) {
+		return []byte{0, 5, 0, 6}, nil
+	}))
+	mux.HandleFunc(FuncCodeWriteMultipleRegisters, func(request *Request) ([]byte, error) {
+		if !bytes.Equal([]byte{0, 1, 0, 2}, request.Values) {
+			t.Errorf("unexpected values: %v", request.Values)
+		}
+		return nil, nil
+	})
+	mux.HandleFunc(0x41, func(request *Request) ([]byte, error) {
+		return append([]byte{0xAA}, request.Data...), nil
+	})
+
+	tests := []struct {
+		slaveId  byte
+		request  ProtocolDataUnit
+		response ProtocolDataUnit
+	}{
+		// Byte count is added
+		{1, ProtocolDataUnit{4, []byte{0, 2, 0, 2}}, ProtocolDataUnit{4, []byte{4, 0, 3, 0, 4}}},
+		// Slave handler
+		{5, ProtocolDataUnit{4, []byte{0, 2, 0, 2}}, ProtocolDataUnit{4, []byte{4, 0, 5, 0, 6}}},
+		// Write response echoes address and quantity
+		{1, ProtocolDataUnit{16, []byte{0, 1, 0, 2, 4, 0, 1, 0, 2}}, ProtocolDataUnit{16, []byte{0, 1, 0, 2}}},
+		// Invalid byte count
+		{1, ProtocolDataUnit{16, []byte{0, 1, 0, 2, 3, 0, 1, 0}}, ProtocolDataUnit{0x90, []byte{3}}},
+		// User defined function
+		{1, ProtocolDataUnit{0x41, []byte{1, 2}}, ProtocolDataUnit{0x41, []byte{0xAA, 1, 2}}},
+		// Not registered
+		{1, ProtocolDataUnit{3, []byte{0, 0, 0, 1}}, ProtocolDataUnit{0x83, []byte{1}}},
+	}
+	for _, test := range tests {
+		response := serve(mux, test.slaveId, &test.request)
+		if response.FunctionCode != test.response.FunctionCode || !bytes.Equal(response.Data, test.response.Data) {
+			t.Errorf("request %v: expected %v, actual %v", test.request, test.response, *response)
+		}
+	}
+
+	mux.HandleSlave(5, FuncCodeReadInputRegisters, nil)
+	if response := serve(mux, 5, &ProtocolDataUnit{4, []byte{0, 2, 0, 2}}); !bytes.Equal([]byte{4, 0, 3, 0, 4}, response.Data) {
+		t.Fatalf("unexpected response: %v", response)
+	}
+}
+
+func TestServeMuxResultsSize(t *testing.T) {
+	mux := NewServeMux()
+	mux.HandleFunc(FuncCodeReadCoils, func(request *Request) ([]byte, error) {
+		return []byte{1}, nil
+	})
+	response := serve(mux, 1, &ProtocolDataUnit{1, []byte{0, 0, 0, 9}})
+	if response.FunctionCode != 0x81 || !bytes.Equal([]byte{ExceptionCodeServerDeviceFailure}, response.Data) {
+		t.Fatalf("unexpected response: %v", response)
+	}
+}
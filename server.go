@@ -0,0 +1,56 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"errors"
+)
+
+// ErrServerClosed is returned by the server's Serve methods after a call to Close.
+var ErrServerClosed = errors.New("modbus: server closed")
+
+// ServerHandler responds to a modbus request.
+//
+// ServeModbus returns the response PDU for a request addressed to slaveId.
+// If err is a *ModbusError, its exception code is sent back to the client,
+// any other error is reported as ExceptionCodeServerDeviceFailure.
+// A nil response without error means no response is sent.
+type ServerHandler interface {
+	ServeModbus(slaveId byte, request *ProtocolDataUnit) (response *ProtocolDataUnit, err error)
+}
+
+// ServerHandlerFunc is an adapter to allow the use of ordinary functions
+// as server handlers.
+type ServerHandlerFunc func(slaveId byte, request *ProtocolDataUnit) (response *ProtocolDataUnit, err error)
+
+// ServeModbus calls f(slaveId, request).
+func (f ServerHandlerFunc) ServeModbus(slaveId byte, request *ProtocolDataUnit) (response *ProtocolDataUnit, err error) {
+	return f(slaveId, request)
+}
+
+// serve dispatches request to handler and converts the returned error
+// to an exception response.
+func serve(handler ServerHandler, slaveId byte, request *ProtocolDataUnit) (response *ProtocolDataUnit) {
+	if handler == nil {
+		return exceptionResponse(request.FunctionCode, ExceptionCodeIllegalFunction)
+	}
+	response, err := handler.ServeModbus(slaveId, request)
+	if err != nil {
+		var exceptionCode byte = ExceptionCodeServerDeviceFailure
+		if mbError, ok := err.(*ModbusError); ok {
+			exceptionCode = mbError.ExceptionCode
+		}
+		response = exceptionResponse(request.FunctionCode, exceptionCode)
+	}
+	return
+}
+
+// exceptionResponse creates an exception response for the function code.
+func exceptionResponse(functionCode, exceptionCode byte) *ProtocolDataUnit {
+	return &ProtocolDataUnit{
+		FunctionCode: functionCode | 0x80,
+		Data:         []byte{exceptionCode},
+	}
+}
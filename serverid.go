@@ -0,0 +1,74 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"fmt"
+)
+
+const (
+	// Run indicator status in Report Server ID response
+	runIndicatorOff = 0x00
+	runIndicatorOn  = 0xFF
+)
+
+// ServerIdReport is the result of Report Server ID.
+//
+// The length of the server id is device specific. When decoding
+// responses, the server id is assumed to be one byte, which is the most
+// common usage.
+//
+// ServerIdReport is also a Handler reporting its fields so that servers
+// can register it in a ServeMux for FuncCodeReportServerId.
+type ServerIdReport struct {
+	ServerId []byte
+	// Run indicator status, true if ON
+	RunIndicatorStatus bool
+	// Device specific additional data
+	AdditionalData []byte
+}
+
+// HandleModbus responds to Report Server ID requests.
+func (r *ServerIdReport) HandleModbus(request *Request) (results []byte, err error) {
+	if request.FunctionCode != FuncCodeReportServerId {
+		err = &ModbusError{FunctionCode: request.FunctionCode, ExceptionCode: ExceptionCodeIllegalFunction}
+		return
+	}
+	count := len(r.ServerId) + 1 + len(r.AdditionalData)
+	if count > 251 {
+		err = fmt.Errorf("modbus: server id report size '%v' must not exceed '%v'", count, 251)
+		return
+	}
+	results = make([]byte, 0, 1+count)
+	results = append(results, byte(count))
+	results = append(results, r.ServerId...)
+	if r.RunIndicatorStatus {
+		results = append(results, runIndicatorOn)
+	} else {
+		results = append(results, runIndicatorOff)
+	}
+	results = append(results, r.AdditionalData...)
+	return
+}
+
+// decodeServerIdReport decodes data of Report Server ID response.
+func decodeServerIdReport(data []byte) (report *ServerIdReport, err error) {
+	count := int(data[0])
+	length := len(data) - 1
+	if count != length {
+		err = fmt.Errorf("modbus: response data size '%v' does not match count '%v'", length, count)
+		return
+	}
+	if count < 2 {
+		err = fmt.Errorf("modbus: byte count '%v' does not meet minimum '%v'", count, 2)
+		return
+	}
+	report = &ServerIdReport{
+		ServerId:           data[1:2],
+		RunIndicatorStatus: data[2] == runIndicatorOn,
+		AdditionalData:     data[3:],
+	}
+	return
+}
@@ -0,0 +1,73 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestServerIdReport(t *testing.T) {
+	mux := NewServeMux()
+	mux.Handle(FuncCodeReadHoldingRegisters, NewDataModel(0, 0, 10, 0))
+	mux.HandleSlave(17, FuncCodeReportServerId, &ServerIdReport{
+		ServerId:           []byte{0x42},
+		RunIndicatorStatus: true,
+		AdditionalData:     []byte("pump"),
+	})
+
+	serverPort, clientPort := net.Pipe()
+	defer clientPort.Close()
+	s := NewRTUServer("", 17, mux)
+	defer s.Close()
+	go s.Serve(serverPort)
+
+	h := NewRTUClientHandler("")
+	h.SlaveId = 17
+	h.port = clientPort
+	client := NewClient(h)
+
+	report, err := client.ReportServerId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0x42}, report.ServerId) || !report.RunIndicatorStatus || string(report.AdditionalData) != "pump" {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if _, err = client.ReadHoldingRegisters(0, 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerIdReportHandleModbus(t *testing.T) {
+	report := &ServerIdReport{ServerId: []byte{1, 2}}
+	results, err := report.HandleModbus(&Request{FunctionCode: FuncCodeReportServerId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{3, 1, 2, 0}, results) {
+		t.Fatalf("unexpected results: %v", results)
+	}
+	report.AdditionalData = make([]byte, 250)
+	if _, err = report.HandleModbus(&Request{FunctionCode: FuncCodeReportServerId}); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
+func TestDecodeServerIdReport(t *testing.T) {
+	report, err := decodeServerIdReport([]byte{2, 7, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{7}, report.ServerId) || report.RunIndicatorStatus || len(report.AdditionalData) != 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	for _, data := range [][]byte{{3, 7, 0xFF}, {1, 7}} {
+		if _, err = decodeServerIdReport(data); err == nil {
+			t.Fatalf("%v: error expected", data)
+		}
+	}
+}
@@ -5,6 +5,8 @@
 package modbus
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -26,6 +28,9 @@ const (
 	tcpIdleTimeout = 60 * time.Second
 )
 
+// aLongTimeAgo is a deadline in the past to interrupt blocking I/O.
+var aLongTimeAgo = time.Unix(1, 0)
+
 // TCPClientHandler implements Packager and Transporter interface.
 type TCPClientHandler struct {
 	tcpPackager
@@ -41,6 +46,14 @@ func NewTCPClientHandler(address string) *TCPClientHandler {
 	return h
 }
 
+// NewTLSClientHandler allocates a new TCPClientHandler for Modbus/TCP Security.
+// Port 802 is used if address does not contain a port.
+func NewTLSClientHandler(address string, config *tls.Config) *TCPClientHandler {
+	h := NewTCPClientHandler(withDefaultPort(address, tlsPort))
+	h.TLSConfig = config
+	return h
+}
+
 // TCPClient creates TCP client with default handler and given connect string.
 func TCPClient(address string) Client {
 	handler := NewTCPClientHandler(address)
@@ -63,18 +76,29 @@ type tcpPackager struct {
 //  Function code: 1 byte
 //  Data: n bytes
 func (mb *tcpPackager) Encode(pdu *ProtocolDataUnit) (adu []byte, err error) {
+	return mb.encodeSlave(mb.SlaveId, pdu)
+}
+
+// encodeSlave is like Encode but sends the request to unit id slaveId.
+func (mb *tcpPackager) encodeSlave(slaveId byte, pdu *ProtocolDataUnit) (adu []byte, err error) {
+	transactionId := atomic.AddUint32(&mb.transactionId, 1)
+	adu = tcpEncode(uint16(transactionId), slaveId, pdu)
+	return
+}
+
+// tcpEncode creates a TCP frame with the given transaction and unit identifier.
+func tcpEncode(transactionId uint16, unitId byte, pdu *ProtocolDataUnit) (adu []byte) {
 	adu = make([]byte, tcpHeaderSize+1+len(pdu.Data))
 
 	// Transaction identifier
-	transactionId := atomic.AddUint32(&mb.transactionId, 1)
-	binary.BigEndian.PutUint16(adu, uint16(transactionId))
+	binary.BigEndian.PutUint16(adu, transactionId)
 	// Protocol identifier
 	binary.BigEndian.PutUint16(adu[2:], tcpProtocolIdentifier)
 	// Length = sizeof(SlaveId) + sizeof(FunctionCode) + Data
 	length := uint16(1 + 1 + len(pdu.Data))
 	binary.BigEndian.PutUint16(adu[4:], length)
 	// Unit identifier
-	adu[6] = mb.SlaveId
+	adu[6] = unitId
 
 	// PDU
 	adu[tcpHeaderSize] = pdu.FunctionCode
@@ -84,6 +108,20 @@ func (mb *tcpPackager) Encode(pdu *ProtocolDataUnit) (adu []byte, err error) {
 
 // Verify confirms transaction, protocol and unit id.
 func (mb *tcpPackager) Verify(aduRequest []byte, aduResponse []byte) (err error) {
+	if err = verifyTCPHeader(aduRequest, aduResponse); err != nil {
+		return
+	}
+	// Unit id (1 byte)
+	if aduResponse[6] != aduRequest[6] {
+		err = fmt.Errorf("modbus: response unit id '%v' does not match request '%v'", aduResponse[6], aduRequest[6])
+		return
+	}
+	return
+}
+
+// verifyTCPHeader confirms transaction and protocol id, a mismatch means
+// the response does not belong to the request.
+func verifyTCPHeader(aduRequest []byte, aduResponse []byte) (err error) {
 	// Transaction id
 	responseVal := binary.BigEndian.Uint16(aduResponse)
 	requestVal := binary.BigEndian.Uint16(aduRequest)
@@ -98,11 +136,6 @@ func (mb *tcpPackager) Verify(aduRequest []byte, aduResponse []byte) (err error)
 		err = fmt.Errorf("modbus: response protocol id '%v' does not match request '%v'", responseVal, requestVal)
 		return
 	}
-	// Unit id (1 byte)
-	if aduResponse[6] != aduRequest[6] {
-		err = fmt.Errorf("modbus: response unit id '%v' does not match request '%v'", aduResponse[6], aduRequest[6])
-		return
-	}
 	return
 }
 
@@ -136,21 +169,93 @@ type tcpTransporter struct {
 	IdleTimeout time.Duration
 	// Transmission logger
 	Logger *log.Logger
+	// TLS configuration for Modbus/TCP Security, which usually contains
+	// the client certificate
+	TLSConfig *tls.Config
+	// Delay before redialing after a failed dial, doubled for each
+	// subsequent failure up to MaxReconnectBackoff. Zero disables waiting.
+	ReconnectBackoff    time.Duration
+	MaxReconnectBackoff time.Duration
+	// ConnStateChanged is called when the connection is established,
+	// closed or being redialed after a failure, err is the cause of the
+	// failure if any. It is called with the connection locked so it must
+	// not call methods of the handler.
+	ConnStateChanged func(state ConnState, err error)
 
 	// TCP connection
 	mu           sync.Mutex
 	conn         net.Conn
 	closeTimer   *time.Timer
 	lastActivity time.Time
+	// Last connection failure and number of consecutive failed dials
+	connErr      error
+	dialFailures int
+	nextDial     time.Time
+}
+
+// ConnState is the state of the connection of a TCP client.
+type ConnState int
+
+const (
+	// StateConnected is the state after the connection is established.
+	StateConnected ConnState = iota + 1
+	// StateDisconnected is the state after the connection is closed,
+	// either by Close, idle timeout or an error.
+	StateDisconnected
+	// StateReconnecting is the state when redialing after the connection
+	// is broken or a dial failed.
+	StateReconnecting
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateDisconnected:
+		return "disconnected"
+	case StateReconnecting:
+		return "reconnecting"
+	}
+	return "unknown"
 }
 
 // Send sends data to server and ensures response length is greater than header length.
 func (mb *tcpTransporter) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	return mb.SendContext(context.Background(), aduRequest)
+}
+
+// SendContext is like Send but aborts the I/O when ctx is done. The connection
+// is closed if the request fails or is aborted so that a late response is not
+// taken as the response of the next request.
+func (mb *tcpTransporter) SendContext(ctx context.Context, aduRequest []byte) (aduResponse []byte, err error) {
+	return mb.sendContext(ctx, aduRequest, mb.exchange)
+}
+
+// sendOneWay writes the request without reading the response.
+func (mb *tcpTransporter) sendOneWay(ctx context.Context, aduRequest []byte) (err error) {
+	_, err = mb.sendContext(ctx, aduRequest, func(aduRequest []byte) (aduResponse []byte, err error) {
+		mb.logf("modbus: sending % x", aduRequest)
+		_, err = mb.conn.Write(aduRequest)
+		return
+	})
+	return
+}
+
+// sendContext connects and calls exchange with the mutex held and I/O
+// deadline set.
+func (mb *tcpTransporter) sendContext(ctx context.Context, aduRequest []byte,
+	exchange func(aduRequest []byte) (aduResponse []byte, err error)) (aduResponse []byte, err error) {
 	mb.mu.Lock()
 	defer mb.mu.Unlock()
 
+	if err = ctx.Err(); err != nil {
+		return
+	}
 	// Establish a new connection if not connected
-	if err = mb.connect(); err != nil {
+	if err = mb.waitReconnect(ctx); err != nil {
+		return
+	}
+	if err = mb.connect(ctx); err != nil {
 		return
 	}
 	// Set timer to close when idle
@@ -164,35 +269,66 @@ func (mb *tcpTransporter) Send(aduRequest []byte) (aduResponse []byte, err error
 	if err = mb.conn.SetDeadline(timeout); err != nil {
 		return
 	}
+	// Deadline and cancellation of ctx are handled by the watcher
+	stop := watchContext(ctx, mb.conn)
+	aduResponse, err = exchange(aduRequest)
+	stop()
+	if err != nil {
+		// Part of the response may be left unread in the connection
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		mb.disconnect(err)
+	}
+	return
+}
+
+// exchange writes the request and reads the response. Caller must hold the mutex.
+func (mb *tcpTransporter) exchange(aduRequest []byte) (aduResponse []byte, err error) {
 	// Send data
 	mb.logf("modbus: sending % x", aduRequest)
 	if _, err = mb.conn.Write(aduRequest); err != nil {
 		return
 	}
-	// Read header first
 	var data [tcpMaxLength]byte
-	if _, err = io.ReadFull(mb.conn, data[:tcpHeaderSize]); err != nil {
+	if aduResponse, err = readTCPFrame(mb.conn, data[:]); err != nil {
+		aduResponse = nil
+		return
+	}
+	mb.logf("modbus: received % x\n", aduResponse)
+	// Response of another request means the connection is out of sync
+	if err = verifyTCPHeader(aduRequest, aduResponse); err != nil {
+		aduResponse = nil
+	}
+	return
+}
+
+// readTCPFrame reads a frame with application protocol header into data which
+// must be at least tcpMaxLength bytes. If the length in the header is invalid,
+// the header is returned along with the error.
+func readTCPFrame(r io.Reader, data []byte) (adu []byte, err error) {
+	// Read header first
+	if _, err = io.ReadFull(r, data[:tcpHeaderSize]); err != nil {
 		return
 	}
 	// Read length, ignore transaction & protocol id (4 bytes)
 	length := int(binary.BigEndian.Uint16(data[4:]))
 	if length <= 0 {
-		mb.flush(data[:])
+		adu = data[:tcpHeaderSize]
 		err = fmt.Errorf("modbus: length in response header '%v' must not be zero", length)
 		return
 	}
 	if length > (tcpMaxLength - (tcpHeaderSize - 1)) {
-		mb.flush(data[:])
+		adu = data[:tcpHeaderSize]
 		err = fmt.Errorf("modbus: length in response header '%v' must not greater than '%v'", length, tcpMaxLength-tcpHeaderSize+1)
 		return
 	}
 	// Skip unit id
 	length += tcpHeaderSize - 1
-	if _, err = io.ReadFull(mb.conn, data[tcpHeaderSize:length]); err != nil {
+	if _, err = io.ReadFull(r, data[tcpHeaderSize:length]); err != nil {
 		return
 	}
-	aduResponse = data[:length]
-	mb.logf("modbus: received % x\n", aduResponse)
+	adu = data[:length]
 	return
 }
 
@@ -202,21 +338,66 @@ func (mb *tcpTransporter) Connect() error {
 	mb.mu.Lock()
 	defer mb.mu.Unlock()
 
-	return mb.connect()
+	return mb.connect(context.Background())
 }
 
-func (mb *tcpTransporter) connect() error {
+func (mb *tcpTransporter) connect(ctx context.Context) error {
 	if mb.conn == nil {
-		dialer := net.Dialer{Timeout: mb.Timeout}
-		conn, err := dialer.Dial("tcp", mb.Address)
+		if mb.connErr != nil {
+			mb.stateChanged(StateReconnecting, mb.connErr)
+		}
+		dialer := &net.Dialer{Timeout: mb.Timeout}
+		var conn net.Conn
+		var err error
+		if mb.TLSConfig == nil {
+			conn, err = dialer.DialContext(ctx, "tcp", mb.Address)
+		} else {
+			tlsDialer := &tls.Dialer{NetDialer: dialer, Config: mb.TLSConfig}
+			conn, err = tlsDialer.DialContext(ctx, "tcp", mb.Address)
+		}
 		if err != nil {
+			mb.connErr = err
+			mb.dialFailures++
+			mb.nextDial = time.Now().Add(mb.reconnectBackoff())
 			return err
 		}
 		mb.conn = conn
+		mb.connErr = nil
+		mb.dialFailures = 0
+		mb.stateChanged(StateConnected, nil)
 	}
 	return nil
 }
 
+// reconnectBackoff returns the delay before the next dial.
+func (mb *tcpTransporter) reconnectBackoff() time.Duration {
+	policy := RetryPolicy{Backoff: mb.ReconnectBackoff, MaxBackoff: mb.MaxReconnectBackoff}
+	return policy.backoff(mb.dialFailures)
+}
+
+// waitReconnect waits until the backoff delay after the last failed dial
+// is passed. The mutex is released while waiting. Caller must hold the mutex.
+func (mb *tcpTransporter) waitReconnect(ctx context.Context) error {
+	if mb.conn != nil {
+		return nil
+	}
+	delay := time.Until(mb.nextDial)
+	if delay <= 0 {
+		return nil
+	}
+	mb.mu.Unlock()
+	defer mb.mu.Lock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
 func (mb *tcpTransporter) startCloseTimer() {
 	if mb.IdleTimeout <= 0 {
 		return
@@ -236,37 +417,39 @@ func (mb *tcpTransporter) Close() error {
 	return mb.close()
 }
 
-// flush flushes pending data in the connection,
-// returns io.EOF if connection is closed.
-func (mb *tcpTransporter) flush(b []byte) (err error) {
-	if err = mb.conn.SetReadDeadline(time.Now()); err != nil {
-		return
-	}
-	// Timeout setting will be reset when reading
-	if _, err = mb.conn.Read(b); err != nil {
-		// Ignore timeout error
-		if netError, ok := err.(net.Error); ok && netError.Timeout() {
-			err = nil
-		}
-	}
-	return
-}
-
 func (mb *tcpTransporter) logf(format string, v ...interface{}) {
 	if mb.Logger != nil {
 		mb.Logger.Printf(format, v...)
 	}
 }
 
-// closeLocked closes current connection. Caller must hold the mutex before calling this method.
+func (mb *tcpTransporter) stateChanged(state ConnState, err error) {
+	if mb.ConnStateChanged != nil {
+		mb.ConnStateChanged(state, err)
+	}
+}
+
+// close closes current connection. Caller must hold the mutex before calling this method.
 func (mb *tcpTransporter) close() (err error) {
 	if mb.conn != nil {
 		err = mb.conn.Close()
 		mb.conn = nil
+		mb.stateChanged(StateDisconnected, mb.connErr)
 	}
 	return
 }
 
+// disconnect closes the connection which is broken or out of sync due to
+// cause, so that it is redialed for the next request. Caller must hold
+// the mutex.
+func (mb *tcpTransporter) disconnect(cause error) {
+	if mb.conn != nil {
+		mb.logf("modbus: closing connection due to %v", cause)
+		mb.connErr = cause
+		mb.close()
+	}
+}
+
 // closeIdle closes the connection if last activity is passed behind IdleTimeout.
 func (mb *tcpTransporter) closeIdle() {
 	mb.mu.Lock()
@@ -281,3 +464,25 @@ func (mb *tcpTransporter) closeIdle() {
 		mb.close()
 	}
 }
+
+// watchContext interrupts pending I/O of conn when ctx is done until stop
+// is called.
+func watchContext(ctx context.Context, conn net.Conn) (stop func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(aLongTimeAgo)
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
@@ -6,6 +6,8 @@ package modbus
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"io"
 	"net"
 	"testing"
@@ -88,6 +90,146 @@ func TestTCPTransporter(t *testing.T) {
 	}
 }
 
+func TestTCPTransporterContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// Server never responds
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	h := NewTCPClientHandler(ln.Addr().String())
+	h.Timeout = 5 * time.Second
+	defer h.Close()
+	client := NewClient(h)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err = client.ReadHoldingRegistersContext(ctx, 0, 1)
+	if err != context.Canceled {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("request is not aborted: %v", elapsed)
+	}
+	if h.conn != nil {
+		t.Fatal("connection is not closed")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = client.ReadHoldingRegistersContext(ctx, 0, 1)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = client.ReadHoldingRegistersContext(ctx, 0, 1)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestTCPTransporterReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The first connection responds with a wrong transaction id
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn, desync bool) {
+				defer conn.Close()
+				var request [12]byte
+				for {
+					if _, err := io.ReadFull(conn, request[:]); err != nil {
+						return
+					}
+					response := append(request[:4:4], 0, 5, request[6], request[7], 2, 0, 7)
+					if desync {
+						response[1]++
+					}
+					conn.Write(response)
+				}
+			}(conn, i == 0)
+		}
+	}()
+
+	var states []ConnState
+	var errs []error
+	h := NewTCPClientHandler(ln.Addr().String())
+	h.ConnStateChanged = func(state ConnState, err error) {
+		states = append(states, state)
+		errs = append(errs, err)
+	}
+	client := NewClient(h)
+	_, err = client.ReadHoldingRegisters(0, 1)
+	if err == nil || h.conn != nil {
+		t.Fatalf("unexpected error: %v, connection: %v", err, h.conn)
+	}
+	results, err := client.ReadHoldingRegisters(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0, 7}, results) {
+		t.Fatalf("unexpected results: % x", results)
+	}
+	h.Close()
+	expected := []ConnState{StateConnected, StateDisconnected, StateReconnecting, StateConnected, StateDisconnected}
+	if fmt.Sprint(expected) != fmt.Sprint(states) {
+		t.Fatalf("unexpected states: %v", states)
+	}
+	if errs[1] == nil || errs[2] != errs[1] || errs[3] != nil || errs[4] != nil {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+}
+
+func TestTCPTransporterReconnectBackoff(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Connection refused
+	ln.Close()
+
+	h := NewTCPClientHandler(ln.Addr().String())
+	h.ReconnectBackoff = 100 * time.Millisecond
+	h.MaxReconnectBackoff = 150 * time.Millisecond
+	client := NewClient(h)
+	if _, err = client.ReadHoldingRegisters(0, 1); err == nil {
+		t.Fatal("expected error")
+	}
+	start := time.Now()
+	if _, err = client.ReadHoldingRegisters(0, 1); err == nil {
+		t.Fatal("expected error")
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("redialed without backoff: %v", elapsed)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err = client.ReadHoldingRegistersContext(ctx, 0, 1); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if h.dialFailures != 2 {
+		t.Fatalf("unexpected dial failures: %v", h.dialFailures)
+	}
+}
+
 func BenchmarkTCPEncoder(b *testing.B) {
 	encoder := tcpPackager{
 		SlaveId: 10,
@@ -0,0 +1,326 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// Default number of outstanding requests
+	tcpMaxInFlight = 16
+)
+
+// errTransporterClosed is returned to pending requests when the connection is closed.
+var errTransporterClosed = errors.New("modbus: connection closed")
+
+// TCPPipelineClientHandler implements Packager and Transporter interface.
+// Unlike TCPClientHandler, it sends requests from concurrent callers without
+// waiting for the previous responses, which are matched by transaction id.
+type TCPPipelineClientHandler struct {
+	tcpPackager
+	tcpPipelineTransporter
+}
+
+// NewTCPPipelineClientHandler allocates a new TCPPipelineClientHandler.
+func NewTCPPipelineClientHandler(address string) *TCPPipelineClientHandler {
+	h := &TCPPipelineClientHandler{}
+	h.Address = address
+	h.Timeout = tcpTimeout
+	h.IdleTimeout = tcpIdleTimeout
+	h.MaxInFlight = tcpMaxInFlight
+	return h
+}
+
+// tcpPipelineTransporter implements Transporter interface.
+type tcpPipelineTransporter struct {
+	// Connect string
+	Address string
+	// Connect timeout and timeout of each transaction
+	Timeout time.Duration
+	// Idle timeout to close the connection
+	IdleTimeout time.Duration
+	// Maximum number of outstanding requests, must be set before sending
+	MaxInFlight int
+	// Transmission logger
+	Logger *log.Logger
+
+	mu           sync.Mutex
+	conn         net.Conn
+	inFlight     chan struct{}
+	pending      map[uint16]chan tcpPipelineResponse
+	closeTimer   *time.Timer
+	lastActivity time.Time
+}
+
+// transactionTimeoutError is returned when the response of a transaction
+// is not received within Timeout. It implements net.Error.
+type transactionTimeoutError struct {
+	transactionId uint16
+}
+
+func (e *transactionTimeoutError) Error() string {
+	return fmt.Sprintf("modbus: transaction '%v' timed out", e.transactionId)
+}
+
+func (e *transactionTimeoutError) Timeout() bool   { return true }
+func (e *transactionTimeoutError) Temporary() bool { return true }
+
+// tcpPipelineResponse is the response of a transaction passed from the reader.
+type tcpPipelineResponse struct {
+	aduResponse []byte
+	err         error
+}
+
+// Send sends the request and waits for the response with the same
+// transaction id.
+func (mb *tcpPipelineTransporter) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	return mb.SendContext(context.Background(), aduRequest)
+}
+
+// SendContext is like Send but returns when ctx is done. The connection is
+// kept open as a late response is dropped when it is received.
+func (mb *tcpPipelineTransporter) SendContext(ctx context.Context, aduRequest []byte) (aduResponse []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	if len(aduRequest) < tcpHeaderSize {
+		err = fmt.Errorf("modbus: request length '%v' does not meet minimum '%v'", len(aduRequest), tcpHeaderSize)
+		return
+	}
+	// Wait for a free slot, which is bounded by timeout of the transactions in flight
+	inFlight := mb.slots()
+	select {
+	case inFlight <- struct{}{}:
+		defer func() { <-inFlight }()
+	case <-ctx.Done():
+		err = ctx.Err()
+		return
+	}
+	var timeout <-chan time.Time
+	if mb.Timeout > 0 {
+		timer := time.NewTimer(mb.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	transactionId := binary.BigEndian.Uint16(aduRequest)
+	ch, err := mb.write(ctx, transactionId, aduRequest)
+	if err != nil {
+		return
+	}
+	select {
+	case response := <-ch:
+		return response.aduResponse, response.err
+	case <-timeout:
+		err = &transactionTimeoutError{transactionId: transactionId}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	mb.mu.Lock()
+	if mb.pending[transactionId] == ch {
+		delete(mb.pending, transactionId)
+	}
+	mb.mu.Unlock()
+	return
+}
+
+// sendOneWay writes the request without waiting for the response.
+func (mb *tcpPipelineTransporter) sendOneWay(ctx context.Context, aduRequest []byte) (err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if err = mb.connect(ctx); err != nil {
+		return
+	}
+	mb.lastActivity = time.Now()
+	mb.startCloseTimer()
+	var timeout time.Time
+	if mb.Timeout > 0 {
+		timeout = mb.lastActivity.Add(mb.Timeout)
+	}
+	if err = mb.conn.SetWriteDeadline(timeout); err != nil {
+		return
+	}
+	mb.logf("modbus: sending % x", aduRequest)
+	if _, err = mb.conn.Write(aduRequest); err != nil {
+		mb.close(err)
+	}
+	return
+}
+
+// slots returns the semaphore limiting requests in flight.
+func (mb *tcpPipelineTransporter) slots() chan struct{} {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if mb.inFlight == nil {
+		n := mb.MaxInFlight
+		if n <= 0 {
+			n = 1
+		}
+		mb.inFlight = make(chan struct{}, n)
+	}
+	return mb.inFlight
+}
+
+// write registers the transaction and writes the request. The response is
+// delivered to the returned channel.
+func (mb *tcpPipelineTransporter) write(ctx context.Context, transactionId uint16, aduRequest []byte) (ch chan tcpPipelineResponse, err error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	// Establish a new connection if not connected
+	if err = mb.connect(ctx); err != nil {
+		return
+	}
+	if _, ok := mb.pending[transactionId]; ok {
+		err = fmt.Errorf("modbus: transaction '%v' is already in flight", transactionId)
+		return
+	}
+	// Set timer to close when idle
+	mb.lastActivity = time.Now()
+	mb.startCloseTimer()
+	// Set write timeout
+	var timeout time.Time
+	if mb.Timeout > 0 {
+		timeout = mb.lastActivity.Add(mb.Timeout)
+	}
+	if err = mb.conn.SetWriteDeadline(timeout); err != nil {
+		return
+	}
+	ch = make(chan tcpPipelineResponse, 1)
+	mb.pending[transactionId] = ch
+	mb.logf("modbus: sending % x", aduRequest)
+	if _, err = mb.conn.Write(aduRequest); err != nil {
+		// Partial request may have been written
+		mb.close(err)
+		ch = nil
+	}
+	return
+}
+
+// Connect establishes a new connection to the address in Address.
+func (mb *tcpPipelineTransporter) Connect() error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	return mb.connect(context.Background())
+}
+
+// connect dials and starts reading responses. Caller must hold the mutex.
+func (mb *tcpPipelineTransporter) connect(ctx context.Context) error {
+	if mb.conn == nil {
+		dialer := net.Dialer{Timeout: mb.Timeout}
+		conn, err := dialer.DialContext(ctx, "tcp", mb.Address)
+		if err != nil {
+			return err
+		}
+		mb.conn = conn
+		mb.pending = make(map[uint16]chan tcpPipelineResponse)
+		go mb.readResponses(conn, mb.pending)
+	}
+	return nil
+}
+
+// readResponses reads frames from conn and delivers them to the pending
+// transactions until conn is closed.
+func (mb *tcpPipelineTransporter) readResponses(conn net.Conn, pending map[uint16]chan tcpPipelineResponse) {
+	for {
+		data := make([]byte, tcpMaxLength)
+		aduResponse, err := readTCPFrame(conn, data)
+		mb.mu.Lock()
+		if err != nil {
+			if mb.conn == conn {
+				mb.logf("modbus: closing connection due to %v", err)
+				mb.close(err)
+			}
+			mb.mu.Unlock()
+			return
+		}
+		transactionId := binary.BigEndian.Uint16(aduResponse)
+		ch, ok := pending[transactionId]
+		delete(pending, transactionId)
+		if ok {
+			mb.lastActivity = time.Now()
+		}
+		mb.mu.Unlock()
+		if !ok {
+			mb.logf("modbus: dropping response of unknown transaction % x\n", aduResponse)
+			continue
+		}
+		mb.logf("modbus: received % x\n", aduResponse)
+		ch <- tcpPipelineResponse{aduResponse: aduResponse}
+	}
+}
+
+// Close closes current connection. Pending requests are failed.
+func (mb *tcpPipelineTransporter) Close() error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	return mb.close(errTransporterClosed)
+}
+
+// close closes current connection and fails pending requests with reason.
+// Caller must hold the mutex.
+func (mb *tcpPipelineTransporter) close(reason error) (err error) {
+	if mb.conn != nil {
+		err = mb.conn.Close()
+		mb.conn = nil
+		for transactionId, ch := range mb.pending {
+			ch <- tcpPipelineResponse{err: reason}
+			delete(mb.pending, transactionId)
+		}
+		mb.pending = nil
+	}
+	return
+}
+
+func (mb *tcpPipelineTransporter) startCloseTimer() {
+	if mb.IdleTimeout <= 0 {
+		return
+	}
+	if mb.closeTimer == nil {
+		mb.closeTimer = time.AfterFunc(mb.IdleTimeout, mb.closeIdle)
+	} else {
+		mb.closeTimer.Reset(mb.IdleTimeout)
+	}
+}
+
+// closeIdle closes the connection if there is no pending request and last
+// activity is passed behind IdleTimeout.
+func (mb *tcpPipelineTransporter) closeIdle() {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if mb.IdleTimeout <= 0 {
+		return
+	}
+	idle := time.Now().Sub(mb.lastActivity)
+	if len(mb.pending) > 0 {
+		mb.closeTimer.Reset(mb.IdleTimeout)
+		return
+	}
+	if idle >= mb.IdleTimeout {
+		mb.logf("modbus: closing connection due to idle timeout: %v", idle)
+		mb.close(errTransporterClosed)
+	}
+}
+
+func (mb *tcpPipelineTransporter) logf(format string, v ...interface{}) {
+	if mb.Logger != nil {
+		mb.Logger.Printf(format, v...)
+	}
+}
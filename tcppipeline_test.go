@@ -0,0 +1,131 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startPipelineServer accepts one connection, reads count requests of
+// reading holding registers and responds to them in reverse order with the
+// register value being the starting address. Requests to address 0xFFFF are
+// not responded.
+func startPipelineServer(t *testing.T, count int) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		var responses [][]byte
+		for i := 0; i < count; i++ {
+			aduRequest, err := readTCPFrame(conn, make([]byte, tcpMaxLength))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			address := binary.BigEndian.Uint16(aduRequest[tcpHeaderSize+1:])
+			if address == 0xFFFF {
+				continue
+			}
+			pdu := &ProtocolDataUnit{FunctionCode: FuncCodeReadHoldingRegisters, Data: dataBlockSuffix(dataBlock(address))}
+			responses = append(responses, tcpEncode(binary.BigEndian.Uint16(aduRequest), aduRequest[6], pdu))
+		}
+		for i := len(responses) - 1; i >= 0; i-- {
+			if _, err = conn.Write(responses[i]); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		// Wait for client to close
+		conn.Read(make([]byte, 1))
+	}()
+	return ln.Addr().String()
+}
+
+func TestTCPPipelineClientHandler(t *testing.T) {
+	const count = 5
+	h := NewTCPPipelineClientHandler(startPipelineServer(t, count))
+	h.Timeout = 2 * time.Second
+	defer h.Close()
+	client := NewClient(h)
+
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(address uint16) {
+			defer wg.Done()
+			results, err := client.ReadHoldingRegisters(address, 1)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if binary.BigEndian.Uint16(results) != address {
+				t.Errorf("unexpected results of address %v: %v", address, results)
+			}
+		}(uint16(i + 1))
+	}
+	wg.Wait()
+}
+
+func TestTCPPipelineClientHandlerTimeout(t *testing.T) {
+	h := NewTCPPipelineClientHandler(startPipelineServer(t, 2))
+	h.Timeout = 200 * time.Millisecond
+	h.MaxInFlight = 1
+	defer h.Close()
+	client := NewClient(h)
+
+	// Only one request is in flight so the second waits for a free slot
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.ReadHoldingRegisters(0xFFFF, 1)
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	results, err := client.ReadHoldingRegisters(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binary.BigEndian.Uint16(results) != 1 {
+		t.Fatalf("unexpected results: %v", results)
+	}
+	if err = <-done; err == nil {
+		t.Fatal("timeout error expected")
+	}
+	h.mu.Lock()
+	pending := len(h.pending)
+	h.mu.Unlock()
+	if pending != 0 {
+		t.Fatalf("unexpected pending transactions: %v", pending)
+	}
+}
+
+func TestTCPPipelineClientHandlerClose(t *testing.T) {
+	h := NewTCPPipelineClientHandler(startPipelineServer(t, 1))
+	client := NewClient(h)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.ReadHoldingRegisters(0xFFFF, 1)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != errTransporterClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
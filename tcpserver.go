@@ -0,0 +1,296 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// TCPServer serves modbus requests over TCP connections.
+type TCPServer struct {
+	// Listen address
+	Address string
+	// Handler to process requests
+	Handler ServerHandler
+	// Write timeout
+	Timeout time.Duration
+	// Idle timeout to close the connection
+	IdleTimeout time.Duration
+	// Transmission logger
+	Logger *log.Logger
+	// TLS configuration for Modbus/TCP Security. Client certificates should
+	// be required and verified (tls.RequireAndVerifyClientCert).
+	TLSConfig *tls.Config
+	// Authorize reports whether the request is allowed for the role in the
+	// client certificate, which is empty without TLS or role extension.
+	// Requests not authorized are responded with ExceptionCodeIllegalFunction.
+	// All requests are allowed if Authorize is nil.
+	Authorize func(role string, slaveId byte, request *ProtocolDataUnit) bool
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// NewTCPServer allocates a new TCPServer.
+func NewTCPServer(address string, handler ServerHandler) *TCPServer {
+	s := &TCPServer{}
+	s.Address = address
+	s.Handler = handler
+	s.Timeout = tcpTimeout
+	s.IdleTimeout = tcpIdleTimeout
+	return s
+}
+
+// NewTLSServer allocates a new TCPServer for Modbus/TCP Security.
+// Port 802 is used if address does not contain a port.
+func NewTLSServer(address string, config *tls.Config, handler ServerHandler) *TCPServer {
+	s := NewTCPServer(withDefaultPort(address, tlsPort), handler)
+	s.TLSConfig = config
+	return s
+}
+
+// ListenAndServe listens on the TCP address in Address and then calls Serve.
+// Connections are secured with TLS if TLSConfig is set.
+func (s *TCPServer) ListenAndServe() error {
+	ln, err := net.Listen("tcp", s.Address)
+	if err != nil {
+		return err
+	}
+	if s.TLSConfig != nil {
+		ln = tls.NewListener(ln, s.TLSConfig)
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts connections on the listener and serves each of them in
+// a new goroutine. It always returns a non-nil error, which is
+// ErrServerClosed after Close is called. TLSConfig is not applied to ln,
+// which can be created with tls.NewListener.
+func (s *TCPServer) Serve(ln net.Listener) error {
+	if !s.trackListener(ln, true) {
+		ln.Close()
+		return ErrServerClosed
+	}
+	defer s.trackListener(ln, false)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			return err
+		}
+		if !s.trackConn(conn, true) {
+			conn.Close()
+			return ErrServerClosed
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops accepting connections and closes idle connections.
+// Requests being processed are completed before their connections are
+// closed. Close waits until all connections are closed.
+func (s *TCPServer) Close() (err error) {
+	s.mu.Lock()
+	s.closed = true
+	for ln := range s.listeners {
+		if e := ln.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	// Interrupt pending reads, responses can still be written
+	for conn := range s.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return
+}
+
+// serveConn reads requests from the connection and writes back responses
+// until the connection is closed or idle.
+func (s *TCPServer) serveConn(conn net.Conn) {
+	defer s.trackConn(conn, false)
+	defer conn.Close()
+
+	s.logf("modbus: accepted connection from %v", conn.RemoteAddr())
+	role, err := s.handshake(conn)
+	if err != nil {
+		s.logf("modbus: closing connection from %v: %v", conn.RemoteAddr(), err)
+		return
+	}
+	var data [tcpMaxLength]byte
+	for {
+		if !s.startIdleTimer(conn) {
+			return
+		}
+		// Read header first
+		if _, err := io.ReadFull(conn, data[:tcpHeaderSize]); err != nil {
+			if err != io.EOF && !s.isClosed() {
+				s.logf("modbus: closing connection from %v: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		length := int(binary.BigEndian.Uint16(data[4:]))
+		// Unit id and function code are mandatory
+		if length < 2 || length > (tcpMaxLength-(tcpHeaderSize-1)) {
+			s.logf("modbus: closing connection from %v: invalid length in request header '%v'", conn.RemoteAddr(), length)
+			return
+		}
+		// Skip unit id
+		length += tcpHeaderSize - 1
+		if _, err := io.ReadFull(conn, data[tcpHeaderSize:length]); err != nil {
+			s.logf("modbus: closing connection from %v: %v", conn.RemoteAddr(), err)
+			return
+		}
+		aduRequest := data[:length]
+		s.logf("modbus: received % x\n", aduRequest)
+		// Frames of other protocols must be discarded
+		if binary.BigEndian.Uint16(aduRequest[2:]) != tcpProtocolIdentifier {
+			continue
+		}
+		aduResponse := s.handle(role, aduRequest)
+		if aduResponse == nil {
+			continue
+		}
+		if s.Timeout > 0 {
+			conn.SetWriteDeadline(time.Now().Add(s.Timeout))
+		}
+		s.logf("modbus: sending % x\n", aduResponse)
+		if _, err := conn.Write(aduResponse); err != nil {
+			s.logf("modbus: closing connection from %v: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+// handshake completes TLS handshake and returns the role in the client
+// certificate if the connection is secured.
+func (s *TCPServer) handshake(conn net.Conn) (role string, err error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return
+	}
+	if s.Timeout > 0 {
+		tlsConn.SetDeadline(time.Now().Add(s.Timeout))
+	}
+	if err = tlsConn.Handshake(); err != nil {
+		return
+	}
+	if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+		role, err = CertificateRole(certs[0])
+	}
+	return
+}
+
+// handle processes the request frame and returns the response frame or
+// nil if there is no response.
+func (s *TCPServer) handle(role string, aduRequest []byte) (aduResponse []byte) {
+	var packager tcpPackager
+	request, err := packager.Decode(aduRequest)
+	if err != nil {
+		s.logf("modbus: %v", err)
+		return
+	}
+	var response *ProtocolDataUnit
+	if s.Authorize != nil && !s.Authorize(role, aduRequest[6], request) {
+		s.logf("modbus: function '%v' is not authorized for role '%v'", request.FunctionCode, role)
+		response = exceptionResponse(request.FunctionCode, ExceptionCodeIllegalFunction)
+	} else {
+		response = serve(s.Handler, aduRequest[6], request)
+	}
+	if response == nil {
+		return
+	}
+	if len(response.Data) > (tcpMaxLength - tcpHeaderSize - 1) {
+		s.logf("modbus: response data size '%v' must not be greater than '%v'", len(response.Data), tcpMaxLength-tcpHeaderSize-1)
+		response = exceptionResponse(request.FunctionCode, ExceptionCodeServerDeviceFailure)
+	}
+	// Echo transaction and unit identifier
+	aduResponse = tcpEncode(binary.BigEndian.Uint16(aduRequest), aduRequest[6], response)
+	return
+}
+
+// startIdleTimer sets the deadline for the next request to come.
+// It returns false if the server is closed.
+func (s *TCPServer) startIdleTimer(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+	var timeout time.Time
+	if s.IdleTimeout > 0 {
+		timeout = time.Now().Add(s.IdleTimeout)
+	}
+	conn.SetReadDeadline(timeout)
+	return true
+}
+
+func (s *TCPServer) trackListener(ln net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if add {
+		if s.closed {
+			return false
+		}
+		if s.listeners == nil {
+			s.listeners = make(map[net.Listener]struct{})
+		}
+		s.listeners[ln] = struct{}{}
+	} else {
+		delete(s.listeners, ln)
+	}
+	return true
+}
+
+// trackConn adds or removes the connection. The wait group must be
+// updated with the mutex held so that Close does not miss connections.
+func (s *TCPServer) trackConn(conn net.Conn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if add {
+		if s.closed {
+			return false
+		}
+		if s.conns == nil {
+			s.conns = make(map[net.Conn]struct{})
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+	} else {
+		delete(s.conns, conn)
+		s.wg.Done()
+	}
+	return true
+}
+
+func (s *TCPServer) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closed
+}
+
+func (s *TCPServer) logf(format string, v ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, v...)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// startTCPServer serves s on a random local port and returns its address.
+func startTCPServer(t *testing.T, s *TCPServer) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	return ln.Addr().String()
+}
+
+func TestTCPServer(t *testing.T) {
+	handler := ServerHandlerFunc(func(slaveId byte, request *ProtocolDataUnit) (*ProtocolDataUnit, error) {
+		if request.FunctionCode != FuncCodeReadHoldingRegisters {
+			return nil, &ModbusError{ExceptionCode: ExceptionCodeIllegalFunction}
+		}
+		return &ProtocolDataUnit{
+			FunctionCode: request.FunctionCode,
+			Data:         []byte{2, 0, slaveId},
+		}, nil
+	})
+	s := NewTCPServer("", handler)
+	defer s.Close()
+	address := startTCPServer(t, s)
+
+	h := NewTCPClientHandler(address)
+	h.SlaveId = 17
+	defer h.Close()
+	client := NewClient(h)
+	results, err := client.ReadHoldingRegisters(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0, 17}, results) {
+		t.Fatalf("unexpected results: %v", results)
+	}
+	_, err = client.ReadInputRegisters(1, 1)
+	if mbError, ok := err.(*ModbusError); !ok || mbError.ExceptionCode != ExceptionCodeIllegalFunction {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestTCPServerTransactionId(t *testing.T) {
+	handler := ServerHandlerFunc(func(slaveId byte, request *ProtocolDataUnit) (*ProtocolDataUnit, error) {
+		return request, nil
+	})
+	s := NewTCPServer("", handler)
+	defer s.Close()
+	address := startTCPServer(t, s)
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Two requests in one write
+	req := []byte{0x12, 0x34, 0, 0, 0, 3, 5, 0x41, 1, 0xAB, 0xCD, 0, 0, 0, 3, 6, 0x42, 2}
+	if _, err = conn.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	rsp := make([]byte, len(req))
+	if _, err = io.ReadFull(conn, rsp); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(req, rsp) {
+		t.Fatalf("unexpected response: % x", rsp)
+	}
+}
+
+func TestTCPServerIdleTimeout(t *testing.T) {
+	s := NewTCPServer("", nil)
+	s.IdleTimeout = 100 * time.Millisecond
+	defer s.Close()
+	address := startTCPServer(t, s)
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var b [1]byte
+	if _, err = conn.Read(b[:]); err != io.EOF {
+		t.Fatalf("connection is not closed: %v", err)
+	}
+}
+
+func TestTCPServerClose(t *testing.T) {
+	release := make(chan struct{})
+	handler := ServerHandlerFunc(func(slaveId byte, request *ProtocolDataUnit) (*ProtocolDataUnit, error) {
+		<-release
+		return request, nil
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewTCPServer("", handler)
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(ln)
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	req := []byte{0, 1, 0, 0, 0, 3, 1, 0x41, 1}
+	if _, err = conn.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	closed := make(chan error, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		closed <- s.Close()
+	}()
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	// Pending request must be answered
+	rsp := make([]byte, len(req))
+	if _, err = io.ReadFull(conn, rsp); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(req, rsp) {
+		t.Fatalf("unexpected response: % x", rsp)
+	}
+	if err = <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if err = <-served; err != ErrServerClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestTCPClientSend(t *testing.T) {
+	mux := NewServeMux()
+	mux.HandleFunc(0x64, func(request *Request) ([]byte, error) {
+		if len(request.Data) == 0 {
+			return nil, &ModbusError{FunctionCode: request.FunctionCode, ExceptionCode: ExceptionCodeIllegalDataValue}
+		}
+		// Response without data
+		return nil, nil
+	})
+	s := NewTCPServer("", mux)
+	defer s.Close()
+
+	h := NewTCPClientHandler(startTCPServer(t, s))
+	defer h.Close()
+	client := NewClient(h)
+	response, err := client.Send(&ProtocolDataUnit{FunctionCode: 0x64, Data: []byte{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.FunctionCode != 0x64 || len(response.Data) != 0 {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	_, err = client.Send(&ProtocolDataUnit{FunctionCode: 0x64})
+	if mbError, ok := err.(*ModbusError); !ok || mbError.ExceptionCode != ExceptionCodeIllegalDataValue {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestTCPClientUnit(t *testing.T) {
+	handler := ServerHandlerFunc(func(slaveId byte, request *ProtocolDataUnit) (*ProtocolDataUnit, error) {
+		return &ProtocolDataUnit{
+			FunctionCode: request.FunctionCode,
+			Data:         []byte{2, 0, slaveId},
+		}, nil
+	})
+	s := NewTCPServer("", handler)
+	defer s.Close()
+
+	h := NewTCPClientHandler(startTCPServer(t, s))
+	h.SlaveId = 1
+	defer h.Close()
+	client := NewClient(h)
+	errs := make(chan error, 30)
+	for i := 1; i <= cap(errs); i++ {
+		go func(unit Client, slaveId byte) {
+			results, err := unit.ReadHoldingRegisters(0, 1)
+			if err == nil && !bytes.Equal([]byte{0, slaveId}, results) {
+				err = fmt.Errorf("unexpected results of unit %v: %v", slaveId, results)
+			}
+			errs <- err
+		}(client.Unit(byte(i)), byte(i))
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	results, err := client.ReadHoldingRegisters(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0, 1}, results) {
+		t.Fatalf("unexpected results: %v", results)
+	}
+	if h.SlaveId != 1 {
+		t.Fatalf("unexpected slave id: %v", h.SlaveId)
+	}
+}
+
+func TestClientUnitPackager(t *testing.T) {
+	h := NewTCPClientHandler("")
+	client := NewClient2(&customPackager{h}, h)
+	_, err := client.Unit(2).ReadHoldingRegisters(0, 1)
+	if err == nil || err.Error() != "modbus: packager does not support slave id '2'" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// customPackager hides the methods of the embedded packager other than
+// the Packager interface.
+type customPackager struct {
+	Packager
+}
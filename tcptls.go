@@ -0,0 +1,50 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"net"
+)
+
+const (
+	// Modbus/TCP Security port
+	tlsPort = "802"
+)
+
+// RoleOID is the object identifier of the certificate extension containing
+// the role of Modbus/TCP Security clients, encoded as an ASN.1 UTF8String.
+var RoleOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 50316, 802, 1}
+
+// CertificateRole returns the role in the certificate extension, or an
+// empty string if the certificate does not have the extension.
+func CertificateRole(cert *x509.Certificate) (role string, err error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(RoleOID) {
+			continue
+		}
+		var rest []byte
+		if rest, err = asn1.UnmarshalWithParams(ext.Value, &role, "utf8"); err != nil {
+			err = fmt.Errorf("modbus: invalid role in certificate: %v", err)
+			return
+		}
+		if len(rest) > 0 {
+			err = fmt.Errorf("modbus: trailing data after role in certificate")
+			role = ""
+		}
+		return
+	}
+	return
+}
+
+// withDefaultPort appends port to address if it does not contain one.
+func withDefaultPort(address, port string) string {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return net.JoinHostPort(address, port)
+	}
+	return address
+}
@@ -0,0 +1,181 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testCA issues certificates for testing.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "modbus test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue creates a certificate with the role extension if role is not empty.
+func (ca *testCA) issue(t *testing.T, serial int64, role string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "modbus test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	if role != "" {
+		value, err := asn1.MarshalWithParams(role, "utf8")
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.ExtraExtensions = []pkix.Extension{{Id: RoleOID, Value: value}}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestTLSServer(t *testing.T) {
+	ca := newTestCA(t)
+	m := NewDataModel(10, 10, 10, 10)
+	s := NewTLSServer("", &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, 2, "")},
+		ClientCAs:    ca.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}, m)
+	// Only operator can write
+	s.Authorize = func(role string, slaveId byte, request *ProtocolDataUnit) bool {
+		switch request.FunctionCode {
+		case FuncCodeReadHoldingRegisters:
+			return true
+		default:
+			return role == "operator"
+		}
+	}
+	defer s.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(tls.NewListener(ln, s.TLSConfig))
+
+	newClient := func(certs ...tls.Certificate) (Client, *TCPClientHandler) {
+		h := NewTLSClientHandler(ln.Addr().String(), &tls.Config{
+			Certificates: certs,
+			RootCAs:      ca.pool,
+		})
+		h.Timeout = 2 * time.Second
+		return NewClient(h), h
+	}
+
+	operator, h := newClient(ca.issue(t, 3, "operator"))
+	defer h.Close()
+	if _, err = operator.WriteSingleRegister(1, 7); err != nil {
+		t.Fatal(err)
+	}
+	viewer, h := newClient(ca.issue(t, 4, "viewer"))
+	defer h.Close()
+	results, err := viewer.ReadHoldingRegisters(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[1] != 7 {
+		t.Fatalf("unexpected results: %v", results)
+	}
+	_, err = viewer.WriteSingleRegister(1, 8)
+	if mbError, ok := err.(*ModbusError); !ok || mbError.ExceptionCode != ExceptionCodeIllegalFunction {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Client certificate is required
+	anonymous, h := newClient()
+	defer h.Close()
+	if _, err = anonymous.ReadHoldingRegisters(1, 1); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
+func TestCertificateRole(t *testing.T) {
+	ca := newTestCA(t)
+	for _, role := range []string{"", "operator"} {
+		cert, err := x509.ParseCertificate(ca.issue(t, 2, role).Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := CertificateRole(cert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != role {
+			t.Fatalf("unexpected role: %q, expected %q", actual, role)
+		}
+	}
+	// Role must be UTF8String
+	value, _ := asn1.Marshal(42)
+	cert := &x509.Certificate{Extensions: []pkix.Extension{{Id: RoleOID, Value: value}}}
+	if _, err := CertificateRole(cert); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
+func TestWithDefaultPort(t *testing.T) {
+	tests := []struct {
+		address  string
+		expected string
+	}{
+		{"localhost", "localhost:802"},
+		{"localhost:8802", "localhost:8802"},
+		{"::1", "[::1]:802"},
+		{"[::1]:8802", "[::1]:8802"},
+	}
+	for _, test := range tests {
+		if actual := withDefaultPort(test.address, tlsPort); actual != test.expected {
+			t.Errorf("%v: expected %v, actual %v", test.address, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// Default UDP timeout of each attempt and number of retries
+	udpTimeout = 2 * time.Second
+	udpRetries = 2
+)
+
+// UDPClientHandler implements Packager and Transporter interface.
+// Each request and response is an application protocol frame in a datagram.
+type UDPClientHandler struct {
+	tcpPackager
+	udpTransporter
+}
+
+// NewUDPClientHandler allocates a new UDPClientHandler.
+func NewUDPClientHandler(address string) *UDPClientHandler {
+	h := &UDPClientHandler{}
+	h.Address = address
+	h.Timeout = udpTimeout
+	h.Retries = udpRetries
+	h.IdleTimeout = tcpIdleTimeout
+	return h
+}
+
+// UDPClient creates UDP client with default handler and given connect string.
+func UDPClient(address string) Client {
+	handler := NewUDPClientHandler(address)
+	return NewClient(handler)
+}
+
+// udpTransporter implements Transporter interface.
+type udpTransporter struct {
+	// Connect string
+	Address string
+	// Read timeout of each attempt
+	Timeout time.Duration
+	// Number of times the request is sent again when there is no response
+	Retries int
+	// Idle timeout to close the socket
+	IdleTimeout time.Duration
+	// Transmission logger
+	Logger *log.Logger
+
+	mu           sync.Mutex
+	conn         net.Conn
+	closeTimer   *time.Timer
+	lastActivity time.Time
+}
+
+// Send sends the request datagram and waits for the response with the same
+// transaction id. The request is sent again if no response is received
+// within Timeout.
+func (mb *udpTransporter) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	return mb.SendContext(context.Background(), aduRequest)
+}
+
+// SendContext is like Send but stops retrying and returns when ctx is done.
+func (mb *udpTransporter) SendContext(ctx context.Context, aduRequest []byte) (aduResponse []byte, err error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	if err = mb.connect(ctx); err != nil {
+		return
+	}
+	// Set timer to close when idle
+	mb.lastActivity = time.Now()
+	mb.startCloseTimer()
+
+	stop := watchContext(ctx, mb.conn)
+	defer stop()
+	for attempt := 0; ; attempt++ {
+		var timeout time.Time
+		if mb.Timeout > 0 {
+			timeout = time.Now().Add(mb.Timeout)
+		}
+		if err = mb.conn.SetDeadline(timeout); err != nil {
+			return
+		}
+		mb.logf("modbus: sending % x", aduRequest)
+		if _, err = mb.conn.Write(aduRequest); err != nil {
+			break
+		}
+		if aduResponse, err = mb.readResponse(aduRequest); err == nil {
+			return
+		}
+		// Only retry when the response is lost
+		if netError, ok := err.(net.Error); !ok || !netError.Timeout() || attempt >= mb.Retries {
+			break
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		mb.logf("modbus: no response, retrying: %v", err)
+	}
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return
+}
+
+// sendOneWay sends the request datagram without waiting for the response.
+func (mb *udpTransporter) sendOneWay(ctx context.Context, aduRequest []byte) (err error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	if err = mb.connect(ctx); err != nil {
+		return
+	}
+	mb.lastActivity = time.Now()
+	mb.startCloseTimer()
+	mb.logf("modbus: sending % x", aduRequest)
+	_, err = mb.conn.Write(aduRequest)
+	return
+}
+
+// readResponse reads datagrams until the response of the request is
+// received, dropping stray and duplicate datagrams.
+func (mb *udpTransporter) readResponse(aduRequest []byte) (aduResponse []byte, err error) {
+	var data [tcpMaxLength]byte
+	for {
+		var n int
+		if n, err = mb.conn.Read(data[:]); err != nil {
+			return
+		}
+		aduResponse = data[:n]
+		if n < tcpHeaderSize+1 || int(binary.BigEndian.Uint16(aduResponse[4:])) != n-tcpHeaderSize+1 {
+			mb.logf("modbus: dropping invalid datagram % x\n", aduResponse)
+			continue
+		}
+		// Transaction and protocol id
+		if !bytes.Equal(aduResponse[:4], aduRequest[:4]) {
+			mb.logf("modbus: dropping datagram of other transaction % x\n", aduResponse)
+			continue
+		}
+		mb.logf("modbus: received % x\n", aduResponse)
+		return
+	}
+}
+
+// Connect creates the socket to the address in Address.
+func (mb *udpTransporter) Connect() error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	return mb.connect(context.Background())
+}
+
+func (mb *udpTransporter) connect(ctx context.Context) error {
+	if mb.conn == nil {
+		dialer := net.Dialer{Timeout: mb.Timeout}
+		conn, err := dialer.DialContext(ctx, "udp", mb.Address)
+		if err != nil {
+			return err
+		}
+		mb.conn = conn
+	}
+	return nil
+}
+
+// Close closes the socket.
+func (mb *udpTransporter) Close() error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	return mb.close()
+}
+
+// close closes the socket. Caller must hold the mutex.
+func (mb *udpTransporter) close() (err error) {
+	if mb.conn != nil {
+		err = mb.conn.Close()
+		mb.conn = nil
+	}
+	return
+}
+
+func (mb *udpTransporter) startCloseTimer() {
+	if mb.IdleTimeout <= 0 {
+		return
+	}
+	if mb.closeTimer == nil {
+		mb.closeTimer = time.AfterFunc(mb.IdleTimeout, mb.closeIdle)
+	} else {
+		mb.closeTimer.Reset(mb.IdleTimeout)
+	}
+}
+
+// closeIdle closes the socket if last activity is passed behind IdleTimeout.
+func (mb *udpTransporter) closeIdle() {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if mb.IdleTimeout <= 0 {
+		return
+	}
+	idle := time.Now().Sub(mb.lastActivity)
+	if idle >= mb.IdleTimeout {
+		mb.logf("modbus: closing connection due to idle timeout: %v", idle)
+		mb.close()
+	}
+}
+
+func (mb *udpTransporter) logf(format string, v ...interface{}) {
+	if mb.Logger != nil {
+		mb.Logger.Printf(format, v...)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUDPClientHandler(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	go func() {
+		var b [tcpMaxLength]byte
+		for i := 0; ; i++ {
+			n, addr, err := conn.ReadFrom(b[:])
+			if err != nil {
+				return
+			}
+			// First request is lost
+			if i == 0 {
+				continue
+			}
+			transactionId := binary.BigEndian.Uint16(b[:n])
+			pdu := &ProtocolDataUnit{FunctionCode: b[tcpHeaderSize], Data: dataBlockSuffix(dataBlock(transactionId))}
+			response := tcpEncode(transactionId, b[6], pdu)
+			datagrams := [][]byte{
+				// Other transaction
+				tcpEncode(transactionId+100, b[6], pdu),
+				// Invalid length
+				response[:tcpHeaderSize+2],
+				response,
+				// Duplicate
+				response,
+			}
+			for _, datagram := range datagrams {
+				if _, err = conn.WriteTo(datagram, addr); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}
+	}()
+
+	h := NewUDPClientHandler(conn.LocalAddr().String())
+	h.Timeout = 100 * time.Millisecond
+	h.Retries = 1
+	defer h.Close()
+	client := NewClient(h)
+
+	for i := 1; i <= 2; i++ {
+		results, err := client.ReadInputRegisters(0, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Register value is the transaction id
+		if !bytes.Equal(dataBlock(uint16(i)), results) {
+			t.Fatalf("unexpected results: %v", results)
+		}
+	}
+}
+
+func TestUDPClientHandlerTimeout(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	requests := make(chan struct{}, 10)
+	go func() {
+		var b [tcpMaxLength]byte
+		for {
+			if _, _, err := conn.ReadFrom(b[:]); err != nil {
+				return
+			}
+			requests <- struct{}{}
+		}
+	}()
+
+	h := NewUDPClientHandler(conn.LocalAddr().String())
+	h.Timeout = 50 * time.Millisecond
+	h.Retries = 2
+	defer h.Close()
+
+	if _, err = NewClient(h).ReadInputRegisters(0, 1); err == nil {
+		t.Fatal("timeout error expected")
+	}
+	if len(requests) != 3 {
+		t.Fatalf("unexpected number of requests: %v", len(requests))
+	}
+}